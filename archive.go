@@ -2,27 +2,151 @@ package archive
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 )
 
 func Run(ctx context.Context, config *Config) error {
-	slackCollectorConfig := NewSlackCollectorConfig(config)
-	collector := NewSlackCollector(config, slackCollectorConfig)
+	collector := config.Collector
+	if collector == nil {
+		slackCollectorConfig := NewSlackCollectorConfig(config)
+		collector = NewSlackCollector(config, slackCollectorConfig)
+	}
 	defer collector.Clean()
 
-	outputs, err := collector.Execute(ctx)
+	var checkpoint *Checkpoint
+	if config.Incremental {
+		if config.CheckpointStore == nil {
+			return fmt.Errorf("CheckpointStore is required in incremental mode")
+		}
+		checkpoint = newCheckpoint()
+	}
+	now := time.Now()
+
+	targets, err := collector.Targets(ctx)
 	if err != nil {
 		return err
 	}
-
-	if err := config.FileExporter.WriteFiles(ctx, outputs.LocalFiles()); err != nil {
+	if err := checkDestination(config.TextExporter, len(targets) > 1); err != nil {
 		return err
 	}
 
-	bytes := config.Formatter.Format(outputs, config.FileExporter.FormatFileName)
+	// NOTE: 1チャンネルの失敗で残りのチャンネルを止めないよう、エラーはまとめて返す
+	var errs []error
+	for _, target := range targets {
+		if checkpoint != nil {
+			ccp, err := config.CheckpointStore.Load(ctx, target.ChannelID)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("channel %s: %w", target.ChannelID, err))
+				continue
+			}
+			if ccp != nil {
+				checkpoint.Channels[target.ChannelID] = ccp
+			}
+			if err := checkpoint.apply(target); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
 
-	if err := config.TextExporter.Write(ctx, bytes); err != nil {
-		return err
+		outputs, err := runTarget(ctx, config, collector, target)
+		if err != nil {
+			config.Logger.Error("failed to archive channel", "channel", target.ChannelID, "error", err.Error())
+			errs = append(errs, fmt.Errorf("channel %s: %w", target.ChannelID, err))
+			continue
+		}
+
+		if checkpoint == nil {
+			continue
+		}
+		if !checkpoint.update(target, outputs, now, config.threadLookback()) {
+			config.Logger.Warn("checkpoint is not updated because the archive is incomplete. Increase the page limit to move on.", "channel", target.ChannelID)
+			continue
+		}
+		// NOTE: チャンネルごとに保存するので、途中のチャンネルで失敗してもそれまでの進捗は残る
+		if err := config.CheckpointStore.Save(ctx, target.ChannelID, checkpoint.Channels[target.ChannelID]); err != nil {
+			errs = append(errs, fmt.Errorf("channel %s: %w", target.ChannelID, err))
+		}
 	}
 
+	return errors.Join(errs...)
+}
+
+// textDestination is implemented by text exporters which write to a path or key template.
+type textDestination interface {
+	// sharedDestination returns the destination if every channel would overwrite it, or "".
+	sharedDestination() string
+}
+
+// destinationValidator is implemented by text exporters whose settings can conflict with each other.
+type destinationValidator interface {
+	validateDestination() error
+}
+
+func hasChannelPlaceholder(s string) bool {
+	return strings.Contains(s, "{channel}") || strings.Contains(s, "{channel_name}")
+}
+
+// checkDestination rejects text exporters which would fail or overwrite archives,
+// such as a destination which is the same for all channels of a multi-channel run.
+// NOTE: 固定のファイル名だとチャンネルごとに上書きされ、最後のチャンネルしか残らない
+func checkDestination(exporter TextExporterInterface, multiChannel bool) error {
+	if multi, ok := exporter.(*MultiTextExporter); ok {
+		for _, exp := range multi.exporters {
+			if err := checkDestination(exp, multiChannel); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if v, ok := exporter.(destinationValidator); ok {
+		if err := v.validateDestination(); err != nil {
+			return err
+		}
+	}
+	if !multiChannel {
+		return nil
+	}
+	d, ok := exporter.(textDestination)
+	if !ok {
+		return nil
+	}
+	if dst := d.sharedDestination(); dst != "" {
+		return fmt.Errorf("%T: the destination %s needs {channel} or {channel_name} to archive multiple channels", exporter, dst)
+	}
 	return nil
 }
+
+func format(formatter FormatterInterface, target *Target, outputs Outputs, formatFileName func(*LocalFile) string) ([]byte, error) {
+	if f, ok := formatter.(FormatterWithErrorInterface); ok {
+		return f.FormatE(target, outputs, formatFileName)
+	}
+	return formatter.Format(target, outputs, formatFileName), nil
+}
+
+func runTarget(ctx context.Context, config *Config, collector CollectorInterface, target *Target) (Outputs, error) {
+	outputs, err := collector.Execute(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := config.FileExporter.WriteFiles(ctx, target, outputs.newFiles()); err != nil {
+		return nil, err
+	}
+
+	formatFileName := func(f *LocalFile) string {
+		return config.FileExporter.FormatFileName(target, f)
+	}
+	bytes, err := format(config.Formatter, target, outputs, formatFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := config.TextExporter.Write(ctx, target, bytes); err != nil {
+		return nil, err
+	}
+
+	return outputs, nil
+}
@@ -0,0 +1,262 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCollector returns fixed outputs per channel and records the calls from Run.
+type fakeCollector struct {
+	targets []*Target
+	outputs map[string]Outputs
+	errs    map[string]error
+
+	mu       sync.Mutex
+	executed []string
+	cleaned  int
+}
+
+var _ CollectorInterface = (*fakeCollector)(nil)
+
+func (c *fakeCollector) Targets(ctx context.Context) ([]*Target, error) {
+	return c.targets, nil
+}
+
+func (c *fakeCollector) Execute(ctx context.Context, target *Target) (Outputs, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.executed = append(c.executed, target.ChannelID)
+	if err := c.errs[target.ChannelID]; err != nil {
+		return nil, err
+	}
+	return c.outputs[target.ChannelID], nil
+}
+
+func (c *fakeCollector) Clean() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cleaned++
+}
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// testFile creates a downloaded file as a collector would.
+func testFile(t *testing.T, id, name string) *LocalFile {
+	p := filepath.Join(t.TempDir(), id)
+	if err := os.WriteFile(p, []byte(id), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return NewLocalFile(id, name, p, time.Date(2024, 7, 1, 0, 0, 0, 0, time.Local))
+}
+
+func twoChannelCollector(t *testing.T) *fakeCollector {
+	return &fakeCollector{
+		targets: []*Target{{ChannelID: "C1"}, {ChannelID: "C2"}},
+		outputs: map[string]Outputs{
+			"C1": {{ID: "1700000001.000000", Username: "alice", Text: "hello C1", LocalFiles: []*LocalFile{testFile(t, "F1", "a.png")}}},
+			"C2": {{ID: "1700000002.000000", Username: "bob", Text: "hello C2", LocalFiles: []*LocalFile{testFile(t, "F2", "b.png")}}},
+		},
+	}
+}
+
+func TestRunWritesPerChannel(t *testing.T) {
+	dir := t.TempDir()
+	collector := twoChannelCollector(t)
+	exporter := NewLocalExporter(testLogger(), filepath.Join(dir, "{channel}.txt"), filepath.Join(dir, "files"))
+
+	err := Run(context.Background(), &Config{
+		Logger:       testLogger(),
+		Collector:    collector,
+		Formatter:    NewTextFormatter("  "),
+		TextExporter: exporter,
+		FileExporter: exporter,
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	for ch, file := range map[string]string{"C1": "C1/F1_a.png", "C2": "C2/F2_b.png"} {
+		b, err := os.ReadFile(filepath.Join(dir, ch+".txt"))
+		if err != nil {
+			t.Fatalf("archive of %s: %v", ch, err)
+		}
+		if text := string(b); !strings.Contains(text, "hello "+ch) || !strings.Contains(text, "(file: "+file+")") {
+			t.Errorf("archive of %s = %q, want its message and file %s", ch, text, file)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "files", file)); err != nil {
+			t.Errorf("file of %s: %v", ch, err)
+		}
+	}
+}
+
+func TestRunRejectsSharedDestination(t *testing.T) {
+	dir := t.TempDir()
+	collector := twoChannelCollector(t)
+	exporter := NewLocalExporter(testLogger(), filepath.Join(dir, "archive.txt"), filepath.Join(dir, "files"))
+
+	err := Run(context.Background(), &Config{
+		Logger:       testLogger(),
+		Collector:    collector,
+		Formatter:    NewTextFormatter("  "),
+		TextExporter: NewMultiTextExporter(FailFast, &NoneExporter{}, exporter),
+		FileExporter: exporter,
+	})
+	if err == nil || !strings.Contains(err.Error(), "{channel}") {
+		t.Fatalf("Run: %v, want an error about the shared destination", err)
+	}
+	if len(collector.executed) != 0 {
+		t.Errorf("executed %v, want no channel archived", collector.executed)
+	}
+	if collector.cleaned != 1 {
+		t.Errorf("Clean called %d times, want 1", collector.cleaned)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "archive.txt")); err == nil {
+		t.Error("archive.txt is written")
+	}
+}
+
+// recordingFormatter records the outputs it formats.
+type recordingFormatter struct {
+	outputs map[string]Outputs
+}
+
+func (f *recordingFormatter) Format(target *Target, outputs Outputs, writeFileName func(*LocalFile) string) []byte {
+	f.outputs[target.ChannelID] = outputs
+	return []byte(target.ChannelID)
+}
+
+// recordingExporter records the texts and files written per channel.
+type recordingExporter struct {
+	texts map[string]string
+	files map[string][]*LocalFile
+}
+
+func (e *recordingExporter) Write(ctx context.Context, target *Target, data []byte) error {
+	e.texts[target.ChannelID] = string(data)
+	return nil
+}
+
+func (e *recordingExporter) WriteFiles(ctx context.Context, target *Target, files []*LocalFile) error {
+	e.files[target.ChannelID] = files
+	return nil
+}
+
+func (e *recordingExporter) FormatFileName(target *Target, f *LocalFile) string {
+	return f.name
+}
+
+func TestRunPassesCollectorOutputs(t *testing.T) {
+	collector := twoChannelCollector(t)
+	formatter := &recordingFormatter{outputs: map[string]Outputs{}}
+	exporter := &recordingExporter{texts: map[string]string{}, files: map[string][]*LocalFile{}}
+
+	err := Run(context.Background(), &Config{
+		Logger:       testLogger(),
+		Collector:    collector,
+		Formatter:    formatter,
+		TextExporter: exporter,
+		FileExporter: exporter,
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if collector.cleaned != 1 {
+		t.Errorf("Clean called %d times, want 1", collector.cleaned)
+	}
+	for _, ch := range []string{"C1", "C2"} {
+		want := collector.outputs[ch]
+		if got := formatter.outputs[ch]; len(got) != 1 || got[0] != want[0] {
+			t.Errorf("formatted outputs of %s = %v, want %v", ch, got, want)
+		}
+		if got := exporter.texts[ch]; got != ch {
+			t.Errorf("text of %s = %q, want the formatted text", ch, got)
+		}
+		if got := exporter.files[ch]; len(got) != 1 || got[0] != want[0].LocalFiles[0] {
+			t.Errorf("files of %s = %v, want %v", ch, got, want[0].LocalFiles)
+		}
+	}
+}
+
+func TestRunCleansOnError(t *testing.T) {
+	tests := []struct {
+		name   string
+		config func(c *fakeCollector) *Config
+	}{
+		{
+			name: "channel error",
+			config: func(c *fakeCollector) *Config {
+				c.errs = map[string]error{"C1": errors.New("channel_not_found")}
+				return &Config{}
+			},
+		},
+		{
+			name: "no checkpoint store",
+			config: func(c *fakeCollector) *Config {
+				return &Config{Incremental: true}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := twoChannelCollector(t)
+			conf := tt.config(collector)
+			conf.Logger = testLogger()
+			conf.Collector = collector
+			conf.Formatter = &recordingFormatter{outputs: map[string]Outputs{}}
+			exporter := &recordingExporter{texts: map[string]string{}, files: map[string][]*LocalFile{}}
+			conf.TextExporter = exporter
+			conf.FileExporter = exporter
+
+			if err := Run(context.Background(), conf); err == nil {
+				t.Fatal("Run succeeded, want an error")
+			}
+			// NOTE: cmdはRunがエラーを返すとos.Exitするので、Run自身が一時ファイルを消す必要がある
+			if collector.cleaned != 1 {
+				t.Errorf("Clean called %d times, want 1", collector.cleaned)
+			}
+		})
+	}
+}
+
+func TestRunRejectsPerRunWithoutRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		logFile string
+		targets []*Target
+	}{
+		{name: "no run placeholder", logFile: "{channel}.txt", targets: []*Target{{ChannelID: "C1"}}},
+		{name: "no channel placeholder in multiple channels", logFile: "{run}.txt", targets: []*Target{{ChannelID: "C1"}, {ChannelID: "C2"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			collector := &fakeCollector{targets: tt.targets}
+			exporter := NewLocalExporter(testLogger(), filepath.Join(dir, tt.logFile), filepath.Join(dir, "files"))
+			exporter.WriteMode = LocalWritePerRun
+
+			err := Run(context.Background(), &Config{
+				Logger:       testLogger(),
+				Collector:    collector,
+				Formatter:    NewTextFormatter("  "),
+				TextExporter: exporter,
+				FileExporter: exporter,
+			})
+			if err == nil {
+				t.Fatal("Run succeeded, want an error about the per-run path")
+			}
+			if len(collector.executed) != 0 {
+				t.Errorf("executed %v, want no channel archived", collector.executed)
+			}
+		})
+	}
+}
@@ -3,66 +3,241 @@ package archive
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awsConfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/aws-sdk-go-v2/service/ses"
 	sestypes "github.com/aws/aws-sdk-go-v2/service/ses/types"
 )
 
 type S3Exporter struct {
 	s3Client        *s3.Client
+	uploader        *manager.Uploader
 	bucket          string
 	archiveFilename string
 	filesKeyPrefix  string
 
+	// region, endpoint and usePathStyle are used to build file URLs.
+	region       string
+	endpoint     *url.URL
+	usePathStyle bool
+
+	// NOTE: バケットは非公開なので、メールの受信者が開けるURLにする
+	presignClient *s3.PresignClient
+	presignExpiry time.Duration
+	baseURL       string
+
+	sse          s3types.ServerSideEncryption
+	sseKMSKeyID  string
+	storageClass s3types.StorageClass
+	tags         map[string]string
+
 	logger *slog.Logger
 }
 
 var _ TextExporterInterface = (*S3Exporter)(nil)
 var _ FileExporterInterface = (*S3Exporter)(nil)
+var _ FileExistsInterface = (*S3Exporter)(nil)
+
+type S3ExporterConfig struct {
+	Bucket string
+	// ArchiveFilename is the key of the text archive, such as "archives/{channel}/{yyyy}/{mm}/{dd}/{since}-{until}.txt".
+	// It can be empty if the exporter is only used as a FileExporter.
+	ArchiveFilename string
+	// FilesKeyPrefix is the key prefix of files. It can contain the same placeholders as ArchiveFilename.
+	FilesKeyPrefix string
+
+	// Region is the region of the bucket. Empty means the bucket location, looked up from AWS.
+	Region string
+	// Endpoint is the URL of S3-compatible storage such as "http://localhost:9000" for MinIO.
+	Endpoint string
+	// UsePathStyle puts the bucket in the URL path instead of the host name. MinIO usually needs it.
+	UsePathStyle bool
+
+	// PresignExpiry makes FormatFileName return presigned GET URLs valid for this duration (up to 7 days).
+	// The links in the archive stop working after that.
+	PresignExpiry time.Duration
+	// BaseURL makes FormatFileName return URLs under it, such as a CloudFront distribution.
+	// "{key}" is replaced with the object key; without it the key is appended to the path.
+	BaseURL string
+
+	// PartSize is the part size of multipart uploads in bytes. Files larger than it are uploaded in parts.
+	// Zero means 5 MiB, the minimum of S3.
+	PartSize int64
+	// UploadConcurrency is the number of parts uploaded in parallel per file. Zero means 5.
+	UploadConcurrency int
+
+	// ServerSideEncryption is "AES256", "aws:kms" or "aws:kms:dsse". Empty means the bucket default.
+	ServerSideEncryption string
+	// SSEKMSKeyID is the KMS key for "aws:kms" and "aws:kms:dsse". Empty means the AWS managed key.
+	SSEKMSKeyID string
+	// StorageClass such as "GLACIER_IR" for the archives and files. Empty means STANDARD.
+	StorageClass string
+	// Tags are put on the archives and files.
+	// Values can contain {channel}, {channel_name} and {archive_date}, the UTC date of the upload.
+	Tags map[string]string
+}
 
 func NewS3Exporter(ctx context.Context, logger *slog.Logger, bucket, archiveFilename, filesKeyPrefix string) (*S3Exporter, error) {
-	if bucket == "" || archiveFilename == "" || filesKeyPrefix == "" {
-		return nil, fmt.Errorf("bucket, archiveFilename and filesKeyPrefix are required.")
+	return NewS3ExporterWithConfig(ctx, logger, &S3ExporterConfig{
+		Bucket:          bucket,
+		ArchiveFilename: archiveFilename,
+		FilesKeyPrefix:  filesKeyPrefix,
+	})
+}
+
+func NewS3ExporterWithConfig(ctx context.Context, logger *slog.Logger, conf *S3ExporterConfig) (*S3Exporter, error) {
+	if conf.Bucket == "" || conf.FilesKeyPrefix == "" {
+		return nil, fmt.Errorf("bucket and filesKeyPrefix are required.")
+	}
+	if conf.PresignExpiry != 0 && conf.BaseURL != "" {
+		return nil, fmt.Errorf("PresignExpiry and BaseURL cannot be used together.")
+	}
+	if conf.PresignExpiry < 0 || conf.PresignExpiry > 7*24*time.Hour {
+		return nil, fmt.Errorf("PresignExpiry must be between 0 and 7 days.")
+	}
+	if conf.ServerSideEncryption != "" && !slices.Contains(s3types.ServerSideEncryption("").Values(), s3types.ServerSideEncryption(conf.ServerSideEncryption)) {
+		return nil, fmt.Errorf("unknown ServerSideEncryption: %s", conf.ServerSideEncryption)
+	}
+	if conf.SSEKMSKeyID != "" && !strings.HasPrefix(conf.ServerSideEncryption, "aws:kms") {
+		return nil, fmt.Errorf("SSEKMSKeyID requires ServerSideEncryption aws:kms or aws:kms:dsse.")
+	}
+	if conf.StorageClass != "" && !slices.Contains(s3types.StorageClass("").Values(), s3types.StorageClass(conf.StorageClass)) {
+		return nil, fmt.Errorf("unknown StorageClass: %s", conf.StorageClass)
+	}
+	if conf.PartSize != 0 && conf.PartSize < manager.MinUploadPartSize {
+		return nil, fmt.Errorf("PartSize must be at least %d bytes.", manager.MinUploadPartSize)
 	}
 
 	cfg, err := awsConfig.LoadDefaultConfig(ctx)
 	if err != nil {
 		return nil, err
 	}
-	s3cli := s3.NewFromConfig(cfg)
+
+	var endpoint *url.URL
+	if conf.Endpoint != "" {
+		endpoint, err = url.Parse(conf.Endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid endpoint: %w", err)
+		}
+	}
+
+	region := conf.Region
+	switch {
+	case region != "":
+	case endpoint == nil:
+		// NOTE: 環境のリージョンとバケットのリージョンが違ってもURLが正しくなるように、バケットの場所を調べる
+		r, err := manager.GetBucketRegion(ctx, s3.NewFromConfig(cfg), conf.Bucket)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get bucket region: %w", err)
+		}
+		region = r
+	case cfg.Region != "":
+		region = cfg.Region
+	default:
+		// NOTE: MinIOなどはリージョンを見ないが、署名に必要なので既定値を入れる
+		region = "us-east-1"
+	}
+
+	s3cli := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.Region = region
+		o.UsePathStyle = conf.UsePathStyle
+		if endpoint != nil {
+			o.BaseEndpoint = aws.String(endpoint.String())
+		}
+	})
+
+	if conf.PresignExpiry != 0 {
+		warnTemporaryCredentials(ctx, logger, cfg, conf.PresignExpiry)
+	}
+
+	uploader := manager.NewUploader(s3cli, func(u *manager.Uploader) {
+		if conf.PartSize != 0 {
+			u.PartSize = conf.PartSize
+		}
+		if conf.UploadConcurrency > 0 {
+			u.Concurrency = conf.UploadConcurrency
+		}
+		// NOTE: 失敗したマルチパートアップロードはAbortして、課金されるパーツを残さない
+		u.LeavePartsOnError = false
+	})
 
 	return &S3Exporter{
 		s3Client:        s3cli,
-		bucket:          bucket,
-		archiveFilename: archiveFilename,
-		filesKeyPrefix:  filesKeyPrefix,
+		uploader:        uploader,
+		bucket:          conf.Bucket,
+		archiveFilename: conf.ArchiveFilename,
+		filesKeyPrefix:  conf.FilesKeyPrefix,
+		region:          region,
+		endpoint:        endpoint,
+		usePathStyle:    conf.UsePathStyle,
+		presignClient:   s3.NewPresignClient(s3cli),
+		presignExpiry:   conf.PresignExpiry,
+		baseURL:         conf.BaseURL,
+		sse:             s3types.ServerSideEncryption(conf.ServerSideEncryption),
+		sseKMSKeyID:     conf.SSEKMSKeyID,
+		storageClass:    s3types.StorageClass(conf.StorageClass),
+		tags:            conf.Tags,
 		logger:          logger,
 	}, nil
 }
 
-func (e *S3Exporter) Write(ctx context.Context, data []byte) error {
-	params := &s3.PutObjectInput{
-		Bucket: aws.String(e.bucket),
-		Key:    aws.String(e.archiveFilename),
-		Body:   bytes.NewReader(data),
+// warnTemporaryCredentials warns that presigned URLs stop working when the session of the credentials ends.
+// NOTE: 署名付きURLは署名した認証情報の期限で無効になる。Lambdaのロールなどの一時的な認証情報では数時間で切れる
+func warnTemporaryCredentials(ctx context.Context, logger *slog.Logger, cfg aws.Config, expiry time.Duration) {
+	creds, err := cfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		logger.Warn("failed to retrieve AWS credentials to check presigned URL expiry", "error", err.Error())
+		return
+	}
+	switch {
+	case creds.CanExpire && creds.Expires.Before(time.Now().Add(expiry)):
+		logger.Warn("presigned URLs expire with the AWS credentials before PresignExpiry. Use long-term credentials or BaseURL.",
+			"credentials_expire", creds.Expires.Format(time.RFC3339),
+			"presign_expiry", expiry.String())
+	case creds.SessionToken != "":
+		logger.Warn("presigned URLs are signed with temporary AWS credentials and expire with the session, possibly before PresignExpiry. Use long-term credentials or BaseURL.",
+			"presign_expiry", expiry.String())
 	}
+}
+
+func (e *S3Exporter) Write(ctx context.Context, target *Target, data []byte) error {
+	if e.archiveFilename == "" {
+		return fmt.Errorf("archiveFilename is required to write the archive.")
+	}
+	key := expandTemplate(e.archiveFilename, target)
+	params := e.putObjectInput(target, key, bytes.NewReader(data), http.DetectContentType(data), nil)
 	if _, err := e.s3Client.PutObject(ctx, params); err != nil {
 		return err
 	}
-	e.logger.Info(fmt.Sprintf("S3Exporter: Write success. s3_object: s3://%s", path.Join(e.bucket, e.archiveFilename)))
+	e.logger.Info(fmt.Sprintf("S3Exporter: Write success. s3_object: s3://%s", path.Join(e.bucket, key)))
 
 	return nil
 }
 
-func (e *S3Exporter) WriteFiles(ctx context.Context, files []*LocalFile) error {
+func (e *S3Exporter) sharedDestination() string {
+	if hasChannelPlaceholder(e.archiveFilename) {
+		return ""
+	}
+	return e.archiveFilename
+}
+
+func (e *S3Exporter) WriteFiles(ctx context.Context, target *Target, files []*LocalFile) error {
 	for _, file := range files {
 		ctype, err := file.detectContentType()
 		if err != nil {
@@ -70,7 +245,7 @@ func (e *S3Exporter) WriteFiles(ctx context.Context, files []*LocalFile) error {
 			continue
 		}
 
-		if err := e.putFileToS3(ctx, file.path, ctype, e.getS3Key(file)); err != nil {
+		if err := e.putFileToS3(ctx, target, file, ctype, e.getS3Key(target, file)); err != nil {
 			return err
 		}
 	}
@@ -78,41 +253,219 @@ func (e *S3Exporter) WriteFiles(ctx context.Context, files []*LocalFile) error {
 	return nil
 }
 
-func (e *S3Exporter) FormatFileName(f *LocalFile) string {
-	return e.getS3Url(f).String()
+func (e *S3Exporter) FormatFileName(target *Target, f *LocalFile) string {
+	key := e.getS3Key(target, f)
+	switch {
+	case e.baseURL != "":
+		return e.baseObjectURL(key)
+	case e.presignExpiry != 0:
+		// NOTE: FormatFileNameはエラーを返せないので、署名に失敗したら署名なしのURLにする
+		req, err := e.presignClient.PresignGetObject(context.Background(), &s3.GetObjectInput{
+			Bucket: aws.String(e.bucket),
+			Key:    aws.String(key),
+		}, s3.WithPresignExpires(e.presignExpiry))
+		if err != nil {
+			e.logger.Error("an error occurred", "function", "PresignGetObject", "key", key, "error", err.Error())
+			break
+		}
+		return req.URL
+	}
+	return e.getS3Url(target, f).String()
+}
+
+// baseObjectURL returns the URL of the object under baseURL.
+func (e *S3Exporter) baseObjectURL(key string) string {
+	segments := strings.Split(strings.TrimPrefix(key, "/"), "/")
+	for i, seg := range segments {
+		segments[i] = url.PathEscape(seg)
+	}
+	escaped := strings.Join(segments, "/")
+
+	if strings.Contains(e.baseURL, "{key}") {
+		return strings.ReplaceAll(e.baseURL, "{key}", escaped)
+	}
+	return strings.TrimSuffix(e.baseURL, "/") + "/" + escaped
 }
 
-func (e *S3Exporter) getS3Key(f *LocalFile) string {
-	return path.Join(e.filesKeyPrefix, fmt.Sprintf("%s_%s", f.id, f.name))
+func (e *S3Exporter) Exists(ctx context.Context, target *Target, f *LocalFile) (bool, error) {
+	res, err := e.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(e.bucket),
+		Key:    aws.String(e.getS3Key(target, f)),
+	})
+	if err != nil {
+		var nf *s3types.NotFound
+		if errors.As(err, &nf) {
+			return false, nil
+		}
+		return false, err
+	}
+	return f.size == 0 || aws.ToInt64(res.ContentLength) == f.size, nil
 }
 
-func (e *S3Exporter) getS3Url(f *LocalFile) *url.URL {
-	return &url.URL{
+func (e *S3Exporter) getS3Key(target *Target, f *LocalFile) string {
+	return filePath(e.filesKeyPrefix, target, f)
+}
+
+func (e *S3Exporter) getS3Url(target *Target, f *LocalFile) *url.URL {
+	return e.objectURL(e.getS3Key(target, f))
+}
+
+// objectURL returns the URL of the object, such as https://bucket.s3.ap-northeast-1.amazonaws.com/key.
+func (e *S3Exporter) objectURL(key string) *url.URL {
+	u := &url.URL{
 		Scheme: "https",
-		Host:   "s3.ap-northeast-1.amazonaws.com",
-		Path:   path.Join("/", e.bucket, e.getS3Key(f)),
+		Host:   fmt.Sprintf("s3.%s.amazonaws.com", e.region),
+	}
+	if e.endpoint != nil {
+		u.Scheme = e.endpoint.Scheme
+		u.Host = e.endpoint.Host
+		u.Path = e.endpoint.Path
 	}
+
+	// NOTE: ドットを含むバケット名はサブドメインになり、ワイルドカード証明書に一致しないのでパス形式にする
+	if e.usePathStyle || strings.Contains(e.bucket, ".") {
+		u.Path = path.Join("/", u.Path, e.bucket, key)
+	} else {
+		u.Host = e.bucket + "." + u.Host
+		u.Path = path.Join("/", u.Path, key)
+	}
+	return u
 }
 
-func (e *S3Exporter) putFileToS3(ctx context.Context, srcPath, contentType, dstKey string) error {
-	f, err := os.Open(srcPath)
+func (e *S3Exporter) putFileToS3(ctx context.Context, target *Target, file *LocalFile, contentType, dstKey string) error {
+	f, err := os.Open(file.path)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
+	// NOTE: 数GBのファイルもあるので、PartSizeを超えるものはマルチパートでアップロードする
+	// *os.FileはReaderAtなので、パーツはメモリにコピーせずファイルから読まれる
+	// NOTE: S3のユーザー定義メタデータはASCIIのみなので、ファイル名は入れない
+	metadata := map[string]string{
+		"slack-file-id": file.id,
+	}
+	if file.user != "" {
+		metadata["slack-file-user"] = file.user
+	}
+	if !file.timestamp.IsZero() {
+		metadata["slack-file-timestamp"] = file.timestamp.UTC().Format(time.RFC3339)
+	}
+	params := e.putObjectInput(target, dstKey, f, contentType, metadata)
+	if _, err := e.uploader.Upload(ctx, params); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", dstKey, err)
+	}
+
+	return nil
+}
+
+// putObjectInput applies the encryption, storage class and tags of the exporter.
+func (e *S3Exporter) putObjectInput(target *Target, key string, body io.Reader, contentType string, metadata map[string]string) *s3.PutObjectInput {
 	params := &s3.PutObjectInput{
-		Bucket: aws.String(e.bucket),
-		Key:    aws.String(dstKey),
-		Body:   f,
+		Bucket:               aws.String(e.bucket),
+		Key:                  aws.String(key),
+		Body:                 body,
+		Metadata:             metadata,
+		ServerSideEncryption: e.sse,
+		StorageClass:         e.storageClass,
 	}
 	if contentType != "" {
 		params.ContentType = aws.String(contentType)
 	}
-	if _, err := e.s3Client.PutObject(ctx, params); err != nil {
+	if e.sseKMSKeyID != "" {
+		params.SSEKMSKeyId = aws.String(e.sseKMSKeyID)
+	}
+	if len(e.tags) != 0 {
+		archiveDate := time.Now().UTC().Format("2006-01-02")
+		tags := url.Values{}
+		for k, v := range e.tags {
+			tags.Set(k, strings.ReplaceAll(expandTemplate(v, target), "{archive_date}", archiveDate))
+		}
+		params.Tagging = aws.String(tags.Encode())
+	}
+	return params
+}
+
+type S3CheckpointStore struct {
+	s3Client *s3.Client
+	bucket   string
+	// key may contain {channel}. See checkpointPath.
+	key string
+
+	// NOTE: 毎回読むのでStorageClassは使わず、暗号化の設定だけ引き継ぐ
+	sse         s3types.ServerSideEncryption
+	sseKMSKeyID string
+
+	logger *slog.Logger
+}
+
+var _ CheckpointStoreInterface = (*S3CheckpointStore)(nil)
+
+// CheckpointStore returns a store which saves the checkpoints in the exporter's bucket, an object per channel.
+// If key is empty, the checkpoints are put in the directory of archiveFilename without placeholders,
+// or under filesKeyPrefix if archiveFilename is empty.
+func (e *S3Exporter) CheckpointStore(key string) *S3CheckpointStore {
+	if key == "" && e.archiveFilename != "" {
+		key = path.Join(path.Dir(templatePrefix(e.archiveFilename)), "checkpoint", "{channel}.json")
+	}
+	if key == "" {
+		key = path.Join(templatePrefix(e.filesKeyPrefix), "checkpoint", "{channel}.json")
+	}
+	return &S3CheckpointStore{
+		s3Client:    e.s3Client,
+		bucket:      e.bucket,
+		key:         key,
+		sse:         e.sse,
+		sseKMSKeyID: e.sseKMSKeyID,
+		logger:      e.logger,
+	}
+}
+
+func (s *S3CheckpointStore) Load(ctx context.Context, channelID string) (*ChannelCheckpoint, error) {
+	key := checkpointPath(s.key, channelID)
+	res, err := s.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var nsk *s3types.NoSuchKey
+		if errors.As(err, &nsk) {
+			s.logger.Info(fmt.Sprintf("S3CheckpointStore: checkpoint not found. s3_object: s3://%s", path.Join(s.bucket, key)))
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalChannelCheckpoint(b)
+}
+
+func (s *S3CheckpointStore) Save(ctx context.Context, channelID string, ccp *ChannelCheckpoint) error {
+	b, err := json.MarshalIndent(ccp, "", "  ")
+	if err != nil {
 		return err
 	}
 
+	key := checkpointPath(s.key, channelID)
+	params := &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(b),
+		ContentType: aws.String("application/json"),
+
+		ServerSideEncryption: s.sse,
+	}
+	if s.sseKMSKeyID != "" {
+		params.SSEKMSKeyId = aws.String(s.sseKMSKeyID)
+	}
+	if _, err := s.s3Client.PutObject(ctx, params); err != nil {
+		return err
+	}
+	s.logger.Info(fmt.Sprintf("S3CheckpointStore: Save success. s3_object: s3://%s", path.Join(s.bucket, key)))
 	return nil
 }
 
@@ -157,14 +510,16 @@ func NewSESTextExporter(ctx context.Context, logger *slog.Logger,
 	}, nil
 }
 
-func (e *SESTextExporter) Write(ctx context.Context, data []byte) error {
+func (e *SESTextExporter) Write(ctx context.Context, target *Target, data []byte) error {
 	mailbody, err := toMIMEBody(data, e.maildata.Boundary)
 	if err != nil {
 		return err
 	}
-	e.maildata.Body = mailbody
+	maildata := *e.maildata
+	maildata.Subject = expandTemplate(e.maildata.Subject, target)
+	maildata.Body = mailbody
 
-	if err := e.sendMail(ctx, e.maildata); err != nil {
+	if err := e.sendMail(ctx, &maildata); err != nil {
 		return err
 	}
 	e.logger.Info("SESTextExporter: Write success.")
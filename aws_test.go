@@ -0,0 +1,38 @@
+package archive
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestS3ExporterObjectURL(t *testing.T) {
+	minio, _ := url.Parse("http://localhost:9000")
+	tests := []struct {
+		name     string
+		exporter *S3Exporter
+		want     string
+	}{
+		{
+			name:     "virtual-hosted",
+			exporter: &S3Exporter{bucket: "archive", region: "ap-northeast-1"},
+			want:     "https://archive.s3.ap-northeast-1.amazonaws.com/files/C1/F1_a.png",
+		},
+		{
+			name:     "bucket with dots",
+			exporter: &S3Exporter{bucket: "archive.example.com", region: "us-east-1"},
+			want:     "https://s3.us-east-1.amazonaws.com/archive.example.com/files/C1/F1_a.png",
+		},
+		{
+			name:     "path style endpoint",
+			exporter: &S3Exporter{bucket: "archive", region: "us-east-1", endpoint: minio, usePathStyle: true},
+			want:     "http://localhost:9000/archive/files/C1/F1_a.png",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.exporter.objectURL("files/C1/F1_a.png").String(); got != tt.want {
+				t.Errorf("objectURL = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
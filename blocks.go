@@ -0,0 +1,236 @@
+package archive
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// Block is a Block Kit block rendered as plain text.
+type Block struct {
+	Type   string   `json:"type"`
+	Text   string   `json:"text,omitempty"`
+	Fields []string `json:"fields,omitempty"`
+}
+
+// Attachment is a legacy message attachment rendered as plain text.
+type Attachment struct {
+	Title     string             `json:"title,omitempty"`
+	TitleLink string             `json:"title_link,omitempty"`
+	Pretext   string             `json:"pretext,omitempty"`
+	Text      string             `json:"text,omitempty"`
+	Fields    []*AttachmentField `json:"fields,omitempty"`
+	Blocks    []*Block           `json:"blocks,omitempty"`
+	Footer    string             `json:"footer,omitempty"`
+}
+
+type AttachmentField struct {
+	Title string `json:"title,omitempty"`
+	Value string `json:"value,omitempty"`
+}
+
+// renderBlocks renders section, context, header, image and rich_text blocks.
+// Other blocks such as actions and divider are dropped.
+func (r *mrkdwnRenderer) renderBlocks(blocks []slack.Block) []*Block {
+	res := []*Block{}
+	for _, block := range blocks {
+		b := &Block{Type: string(block.BlockType())}
+		switch block := block.(type) {
+		case *slack.SectionBlock:
+			b.Text = r.renderTextObject(block.Text)
+			for _, field := range block.Fields {
+				b.Fields = append(b.Fields, r.renderTextObject(field))
+			}
+		case *slack.ContextBlock:
+			texts := []string{}
+			for _, elem := range block.ContextElements.Elements {
+				switch elem := elem.(type) {
+				case *slack.TextBlockObject:
+					texts = append(texts, r.renderTextObject(elem))
+				case *slack.ImageBlockElement:
+					if elem.AltText != "" {
+						texts = append(texts, elem.AltText)
+					}
+				}
+			}
+			b.Text = strings.Join(texts, " ")
+		case *slack.HeaderBlock:
+			b.Text = r.renderTextObject(block.Text)
+		case *slack.ImageBlock:
+			b.Text = fmt.Sprintf("[image: %s] %s", block.AltText, block.ImageURL)
+		case *slack.RichTextBlock:
+			b.Text = r.renderRichTextElements(block.Elements)
+		default:
+			continue
+		}
+		if b.Text == "" && len(b.Fields) == 0 {
+			continue
+		}
+		res = append(res, b)
+	}
+	return res
+}
+
+func (r *mrkdwnRenderer) renderTextObject(obj *slack.TextBlockObject) string {
+	if obj == nil {
+		return ""
+	}
+	if obj.Type == slack.MarkdownType {
+		return r.render(obj.Text)
+	}
+	return obj.Text
+}
+
+func (r *mrkdwnRenderer) renderRichTextElements(elems []slack.RichTextElement) string {
+	texts := []string{}
+	for _, elem := range elems {
+		switch elem := elem.(type) {
+		case *slack.RichTextSection:
+			texts = append(texts, r.renderRichTextSection(elem.Elements))
+		case *slack.RichTextQuote:
+			text := r.renderRichTextSection(elem.Elements)
+			texts = append(texts, "> "+strings.ReplaceAll(text, "\n", "\n> "))
+		case *slack.RichTextPreformatted:
+			texts = append(texts, "```\n"+r.renderRichTextSection(elem.Elements)+"\n```")
+		case *slack.RichTextList:
+			indent := strings.Repeat("  ", elem.Indent)
+			for i, item := range elem.Elements {
+				marker := "- "
+				if elem.Style == slack.RTEListOrdered {
+					marker = fmt.Sprintf("%d. ", i+1)
+				}
+				texts = append(texts, indent+marker+r.renderRichTextElements([]slack.RichTextElement{item}))
+			}
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+func (r *mrkdwnRenderer) renderRichTextSection(elems []slack.RichTextSectionElement) string {
+	var b strings.Builder
+	for _, elem := range elems {
+		switch elem := elem.(type) {
+		case *slack.RichTextSectionTextElement:
+			b.WriteString(elem.Text)
+		case *slack.RichTextSectionUserElement:
+			b.WriteString("@" + r.users.get(elem.UserID))
+		case *slack.RichTextSectionChannelElement:
+			b.WriteString("#" + r.channels.get(elem.ChannelID))
+		case *slack.RichTextSectionUserGroupElement:
+			b.WriteString("@" + r.usergroups.get(elem.UsergroupID))
+		case *slack.RichTextSectionBroadcastElement:
+			b.WriteString("@" + elem.Range)
+		case *slack.RichTextSectionEmojiElement:
+			b.WriteString(":" + elem.Name + ":")
+		case *slack.RichTextSectionLinkElement:
+			if elem.Text == "" || elem.Text == elem.URL {
+				b.WriteString(elem.URL)
+			} else {
+				b.WriteString(elem.Text + " (" + elem.URL + ")")
+			}
+		case *slack.RichTextSectionDateElement:
+			b.WriteString(r.timeFormat.formatTime(elem.Timestamp.Time()))
+		case *slack.RichTextSectionTeamElement:
+			b.WriteString(elem.TeamID)
+		case *slack.RichTextSectionColorElement:
+			b.WriteString(elem.Value)
+		}
+	}
+	return b.String()
+}
+
+func (r *mrkdwnRenderer) renderAttachments(attachments []slack.Attachment) []*Attachment {
+	res := []*Attachment{}
+	for _, a := range attachments {
+		attachment := &Attachment{
+			Title:     r.render(a.Title),
+			TitleLink: a.TitleLink,
+			Pretext:   r.render(a.Pretext),
+			Text:      r.render(a.Text),
+			Blocks:    r.renderBlocks(a.Blocks.BlockSet),
+			Footer:    r.render(a.Footer),
+		}
+		if attachment.Text == "" && len(attachment.Blocks) == 0 {
+			attachment.Text = r.render(a.Fallback)
+		}
+		for _, field := range a.Fields {
+			attachment.Fields = append(attachment.Fields, &AttachmentField{
+				Title: r.render(field.Title),
+				Value: r.render(field.Value),
+			})
+		}
+		res = append(res, attachment)
+	}
+	return res
+}
+
+// blockMentions returns user, channel and usergroup IDs mentioned in blocks and attachments.
+func blockMentions(msg slack.Message) (users, channels, usergroups []string) {
+	texts := []string{}
+	var walkBlocks func(blocks []slack.Block)
+	var walkRichText func(elems []slack.RichTextElement)
+	walkSection := func(elems []slack.RichTextSectionElement) {
+		for _, elem := range elems {
+			switch elem := elem.(type) {
+			case *slack.RichTextSectionUserElement:
+				users = append(users, elem.UserID)
+			case *slack.RichTextSectionChannelElement:
+				channels = append(channels, elem.ChannelID)
+			case *slack.RichTextSectionUserGroupElement:
+				usergroups = append(usergroups, elem.UsergroupID)
+			}
+		}
+	}
+	walkRichText = func(elems []slack.RichTextElement) {
+		for _, elem := range elems {
+			switch elem := elem.(type) {
+			case *slack.RichTextSection:
+				walkSection(elem.Elements)
+			case *slack.RichTextQuote:
+				walkSection(elem.Elements)
+			case *slack.RichTextPreformatted:
+				walkSection(elem.Elements)
+			case *slack.RichTextList:
+				walkRichText(elem.Elements)
+			}
+		}
+	}
+	walkBlocks = func(blocks []slack.Block) {
+		for _, block := range blocks {
+			switch block := block.(type) {
+			case *slack.SectionBlock:
+				if block.Text != nil {
+					texts = append(texts, block.Text.Text)
+				}
+				for _, field := range block.Fields {
+					texts = append(texts, field.Text)
+				}
+			case *slack.ContextBlock:
+				for _, elem := range block.ContextElements.Elements {
+					if obj, ok := elem.(*slack.TextBlockObject); ok {
+						texts = append(texts, obj.Text)
+					}
+				}
+			case *slack.RichTextBlock:
+				walkRichText(block.Elements)
+			}
+		}
+	}
+
+	walkBlocks(msg.Blocks.BlockSet)
+	for _, a := range msg.Attachments {
+		texts = append(texts, a.Title, a.Pretext, a.Text, a.Footer, a.Fallback)
+		for _, field := range a.Fields {
+			texts = append(texts, field.Title, field.Value)
+		}
+		walkBlocks(a.Blocks.BlockSet)
+	}
+
+	for _, text := range texts {
+		users = append(users, mrkdwnMentions(text, mrkdwnUser)...)
+		channels = append(channels, mrkdwnMentions(text, mrkdwnChannel)...)
+		usergroups = append(usergroups, mrkdwnMentions(text, mrkdwnUsergroup)...)
+	}
+	return users, channels, usergroups
+}
@@ -0,0 +1,38 @@
+package archive
+
+import (
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+func TestRenderBlocksDateUsesTimeFormat(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skipf("tzdata: %v", err)
+	}
+	block := slack.NewRichTextBlock("b1", slack.NewRichTextSection(
+		&slack.RichTextSectionTextElement{Type: slack.RTSEText, Text: "due "},
+		slack.NewRichTextSectionDateElement(1700000000),
+	))
+
+	// NOTE: Formatterのタイムゾーンと書式がコレクタに渡る
+	formatter := NewTextFormatter("")
+	formatter.TimeFormat = TimeFormat{Location: tokyo, Layout: "2006-01-02 15:04"}
+	slackConf := NewSlackCollectorConfig(&Config{Formatter: formatter})
+
+	r := &mrkdwnRenderer{
+		users:      newNameCacheClient(),
+		channels:   newNameCacheClient(),
+		usergroups: newNameCacheClient(),
+		timeFormat: slackConf.TimeFormat,
+	}
+	blocks := r.renderBlocks([]slack.Block{block})
+	if len(blocks) != 1 {
+		t.Fatalf("blocks = %d, want 1", len(blocks))
+	}
+	if want := "due 2023-11-15 07:13"; blocks[0].Text != want {
+		t.Errorf("text = %q, want %q", blocks[0].Text, want)
+	}
+}
@@ -0,0 +1,188 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Checkpoint records how far each channel has been archived.
+// Each channel is loaded and saved separately by CheckpointStoreInterface.
+type Checkpoint struct {
+	Channels map[string]*ChannelCheckpoint `json:"channels"`
+}
+
+type ChannelCheckpoint struct {
+	// LatestTimestamp is the newest archived message ts in the channel.
+	LatestTimestamp string `json:"latest_ts"`
+	// Threads maps thread_ts to the newest archived reply ts in the thread.
+	Threads map[string]string `json:"threads,omitempty"`
+}
+
+func newCheckpoint() *Checkpoint {
+	return &Checkpoint{
+		Channels: map[string]*ChannelCheckpoint{},
+	}
+}
+
+// apply continues the target from the last checkpoint of its channel.
+func (cp *Checkpoint) apply(target *Target) error {
+	ccp, ok := cp.Channels[target.ChannelID]
+	if !ok || ccp.LatestTimestamp == "" {
+		return nil
+	}
+
+	since, err := parseSlackTimestamp(ccp.LatestTimestamp)
+	if err != nil {
+		return fmt.Errorf("invalid checkpoint of channel %s: %w", target.ChannelID, err)
+	}
+	target.Since = since
+	target.Checkpoint = ccp
+	return nil
+}
+
+// update records the archived outputs of the target.
+// Threads whose root is older than threadLookback are dropped. threadLookback 0 drops all threads.
+// A truncated target keeps its previous checkpoint and returns false.
+// If only the thread lookback rescan was truncated, the newest ts moves on but the thread replies are not recorded.
+func (cp *Checkpoint) update(target *Target, outputs Outputs, now time.Time, threadLookback time.Duration) bool {
+	// NOTE: 取得が途中で打ち切られた場合、最新のtsまで進めると取れなかった古いメッセージが二度と取られない
+	if target.truncated {
+		return false
+	}
+
+	ccp, ok := cp.Channels[target.ChannelID]
+	if !ok {
+		ccp = &ChannelCheckpoint{}
+		cp.Channels[target.ChannelID] = ccp
+	}
+	if ccp.Threads == nil {
+		ccp.Threads = map[string]string{}
+	}
+
+	for _, output := range outputs {
+		if timestampAfter(output.ID, ccp.LatestTimestamp) {
+			ccp.LatestTimestamp = output.ID
+		}
+		// NOTE: 再走査が打ち切られたスレッドは返信を取りこぼしているかもしれないので、次回も前回の位置から取り直す
+		if target.threadsTruncated {
+			continue
+		}
+		for _, reply := range output.Replies {
+			if timestampAfter(reply.ID, ccp.Threads[output.ID]) {
+				ccp.Threads[output.ID] = reply.ID
+			}
+		}
+	}
+
+	// NOTE: メッセージが無いチャンネルは次回同じ期間を取り直さないよう期間の終わりを記録する
+	if ccp.LatestTimestamp == "" {
+		until := target.Until
+		if until.IsZero() {
+			until = now
+		}
+		ccp.LatestTimestamp = slackTimestamp(until)
+	}
+
+	for threadTs := range ccp.Threads {
+		t, err := parseSlackTimestamp(threadTs)
+		if err != nil || threadLookback <= 0 || t.Before(now.Add(-threadLookback)) {
+			delete(ccp.Threads, threadTs)
+		}
+	}
+	return true
+}
+
+// timestampAfter reports whether Slack ts a is newer than b. Empty b is the oldest.
+func timestampAfter(a, b string) bool {
+	ta, err := parseSlackTimestamp(a)
+	if err != nil {
+		return false
+	}
+	if b == "" {
+		return true
+	}
+	tb, err := parseSlackTimestamp(b)
+	if err != nil {
+		return true
+	}
+	return ta.After(tb)
+}
+
+// checkpointPath expands {channel} of the path template.
+// A template without {channel} gets a directory per channel before the file name.
+func checkpointPath(tmpl, channelID string) string {
+	if strings.Contains(tmpl, "{channel}") {
+		return strings.ReplaceAll(tmpl, "{channel}", channelID)
+	}
+	return path.Join(path.Dir(tmpl), channelID, path.Base(tmpl))
+}
+
+type LocalCheckpointStore struct {
+	// filePath may contain {channel}. See checkpointPath.
+	filePath string
+
+	logger *slog.Logger
+}
+
+var _ CheckpointStoreInterface = (*LocalCheckpointStore)(nil)
+
+func NewLocalCheckpointStore(logger *slog.Logger, filePath string) *LocalCheckpointStore {
+	if filePath == "" {
+		panic("NewLocalCheckpointStore: filePath is required")
+	}
+
+	return &LocalCheckpointStore{
+		filePath: filePath,
+		logger:   logger,
+	}
+}
+
+func (s *LocalCheckpointStore) Load(ctx context.Context, channelID string) (*ChannelCheckpoint, error) {
+	filePath := checkpointPath(s.filePath, channelID)
+	b, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		s.logger.Info(fmt.Sprintf("LocalCheckpointStore: checkpoint not found. file: %s", filePath))
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalChannelCheckpoint(b)
+}
+
+func (s *LocalCheckpointStore) Save(ctx context.Context, channelID string, ccp *ChannelCheckpoint) error {
+	b, err := json.MarshalIndent(ccp, "", "  ")
+	if err != nil {
+		return err
+	}
+	filePath := checkpointPath(s.filePath, channelID)
+	if err := os.MkdirAll(path.Dir(filePath), 0755); err != nil {
+		return err
+	}
+
+	// NOTE: 書き込み途中で落ちてもチェックポイントが壊れないよう、一時ファイルに書いてからrenameする
+	tmpPath := filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, b, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		return err
+	}
+	s.logger.Info(fmt.Sprintf("LocalCheckpointStore: Save success. file: %s", filePath))
+	return nil
+}
+
+func unmarshalChannelCheckpoint(b []byte) (*ChannelCheckpoint, error) {
+	ccp := &ChannelCheckpoint{}
+	if err := json.Unmarshal(b, ccp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal checkpoint: %w", err)
+	}
+	return ccp, nil
+}
@@ -0,0 +1,103 @@
+package archive
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestThreadLookbackDefault(t *testing.T) {
+	tests := []struct {
+		lookback time.Duration
+		want     time.Duration
+	}{
+		{lookback: 0, want: DefaultThreadLookback},
+		{lookback: time.Hour, want: time.Hour},
+		{lookback: -1, want: 0},
+	}
+	for _, tt := range tests {
+		conf := &Config{ThreadLookback: tt.lookback}
+		if got := conf.threadLookback(); got != tt.want {
+			t.Errorf("threadLookback(%s) = %s, want %s", tt.lookback, got, tt.want)
+		}
+		if got := NewSlackCollectorConfig(conf).ThreadLookback; got != tt.want {
+			t.Errorf("SlackCollectorConfig.ThreadLookback(%s) = %s, want %s", tt.lookback, got, tt.want)
+		}
+	}
+}
+
+func TestCheckpointUpdateKeepsThreadsInLookback(t *testing.T) {
+	now, _ := parseSlackTimestamp("1700086400.000000")
+	cp := newCheckpoint()
+	target := &Target{ChannelID: "C1"}
+	outputs := Outputs{
+		{ID: "1700000000.000000", Replies: Outputs{{ID: "1700000001.000000"}}},
+		{ID: "1700080000.000000", Replies: Outputs{{ID: "1700080001.000000"}}},
+	}
+
+	if !cp.update(target, outputs, now, DefaultThreadLookback) {
+		t.Fatal("update failed")
+	}
+	if got := len(cp.Channels["C1"].Threads); got != 2 {
+		t.Errorf("threads = %d, want 2 in the lookback", got)
+	}
+
+	if !cp.update(target, outputs, now, 2*time.Hour) {
+		t.Fatal("update failed")
+	}
+	if _, ok := cp.Channels["C1"].Threads["1700080000.000000"]; !ok || len(cp.Channels["C1"].Threads) != 1 {
+		t.Errorf("threads = %v, want only the thread in the last 2 hours", cp.Channels["C1"].Threads)
+	}
+}
+
+func TestCheckpointPath(t *testing.T) {
+	tests := []struct {
+		tmpl string
+		want string
+	}{
+		{tmpl: "cp/{channel}.json", want: "cp/C1.json"},
+		{tmpl: "cp/checkpoint.json", want: "cp/C1/checkpoint.json"},
+		{tmpl: "checkpoint.json", want: "C1/checkpoint.json"},
+	}
+	for _, tt := range tests {
+		if got := checkpointPath(tt.tmpl, "C1"); got != tt.want {
+			t.Errorf("checkpointPath(%s) = %s, want %s", tt.tmpl, got, tt.want)
+		}
+	}
+}
+
+func TestLocalCheckpointStoreSavesPerChannel(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	filePath := filepath.Join(t.TempDir(), "{channel}.json")
+
+	// NOTE: 別々のチャンネルを並行して実行したときのように、読み込んだ後にそれぞれ保存する
+	s1 := NewLocalCheckpointStore(logger, filePath)
+	s2 := NewLocalCheckpointStore(logger, filePath)
+	for _, s := range []*LocalCheckpointStore{s1, s2} {
+		for _, ch := range []string{"C1", "C2"} {
+			if ccp, err := s.Load(ctx, ch); err != nil || ccp != nil {
+				t.Fatalf("Load(%s) = %v, %v, want nothing saved", ch, ccp, err)
+			}
+		}
+	}
+	if err := s1.Save(ctx, "C1", &ChannelCheckpoint{LatestTimestamp: "1700000001.000000"}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := s2.Save(ctx, "C2", &ChannelCheckpoint{LatestTimestamp: "1700000002.000000"}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	for ch, want := range map[string]string{"C1": "1700000001.000000", "C2": "1700000002.000000"} {
+		ccp, err := s1.Load(ctx, ch)
+		if err != nil {
+			t.Fatalf("Load(%s): %v", ch, err)
+		}
+		if ccp == nil || ccp.LatestTimestamp != want {
+			t.Errorf("Load(%s) = %+v, want latest_ts %s", ch, ccp, want)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"log/slog"
 	"os"
 	"time"
+	_ "time/tzdata" // NOTE: provided.al2023にはzoneinfoが無い場合がある
 
 	archive "github.com/ToshihitoKon/slack-archive"
 	"github.com/aws/aws-lambda-go/events"
@@ -71,17 +72,69 @@ func readLocalRequestJson(filePath string) (events.APIGatewayProxyRequest, error
 func makeConfig(ctx context.Context, req *archiveRequest) (*archive.Config, error) {
 	logger := slog.Default()
 
-	since, err := time.Parse(time.RFC3339, req.Since)
-	if err != nil {
-		return nil, fmt.Errorf("error time.Parse since: %w", err)
+	// NOTE: incrementalの場合はチェックポイントから再開するのでsince, untilは省略できる
+	var since, until time.Time
+	if req.Since != "" || !req.Incremental {
+		t, err := time.Parse(time.RFC3339, req.Since)
+		if err != nil {
+			return nil, fmt.Errorf("error time.Parse since: %w", err)
+		}
+		since = t
+	}
+	if req.Until != "" || !req.Incremental {
+		t, err := time.Parse(time.RFC3339, req.Until)
+		if err != nil {
+			return nil, fmt.Errorf("error time.Parse until: %w", err)
+		}
+		until = t
+	}
+	threadLookback := archive.DefaultThreadLookback
+	if req.ThreadLookback != "" {
+		d, err := time.ParseDuration(req.ThreadLookback)
+		if err != nil {
+			return nil, fmt.Errorf("error time.ParseDuration thread_lookback: %w", err)
+		}
+		threadLookback = d
+	}
+	retry := archive.DefaultRetryPolicy()
+	if req.RetryMaxAttempts != 0 {
+		retry.MaxAttempts = req.RetryMaxAttempts
+	}
+
+	// NOTE: LambdaはUTCで動くので、timezoneが無ければSA_TEXT_FORMATTER_TIMEZONEを使う
+	timezone := req.Timezone
+	if timezone == "" {
+		timezone = archive.Getenv("TEXT_FORMATTER_TIMEZONE")
 	}
-	until, err := time.Parse(time.RFC3339, req.Until)
+	timeLayout := req.TimeLayout
+	if timeLayout == "" {
+		timeLayout = archive.Getenv("TEXT_FORMATTER_TIME_LAYOUT")
+	}
+	timeFormat, err := archive.NewTimeFormat(timezone, timeLayout)
 	if err != nil {
-		return nil, fmt.Errorf("error time.Parse until: %w", err)
+		return nil, fmt.Errorf("error time.LoadLocation timezone: %w", err)
 	}
 
-	replyIndent := archive.Getenv("TEXT_FORMATTER_REPLY_INDENT")
-	formatter := archive.NewTextFormatter(replyIndent)
+	var formatter archive.FormatterInterface
+	switch req.Format {
+	case "", "text":
+		replyIndent := archive.Getenv("TEXT_FORMATTER_REPLY_INDENT")
+		f := archive.NewTextFormatter(replyIndent)
+		f.TimeFormat = timeFormat
+		f.DaySeparator = req.DaySeparator
+		formatter = f
+	case "html":
+		f := archive.NewHTMLFormatter(logger, req.EmbedImages)
+		f.TimeFormat = timeFormat
+		f.DaySeparator = req.DaySeparator
+		if req.HTMLLang != "" {
+			f.Lang = req.HTMLLang
+		}
+		formatter = f
+	default:
+		// NOTE: 出力はSESのメール本文になるので、slack-exportなどのバイナリやJSONは扱わない
+		return nil, fmt.Errorf("unknown format: %s", req.Format)
+	}
 
 	var (
 		configSetName = archive.Getenv("SES_EXPORTER_CONFIG_SET_NAME")
@@ -95,19 +148,63 @@ func makeConfig(ctx context.Context, req *archiveRequest) (*archive.Config, erro
 		return nil, err
 	}
 
-	fileExporter, err := archive.NewS3Exporter(ctx, logger, req.S3Bucket, "dummy", req.S3Key)
-
+	var presignExpiry time.Duration
+	if req.S3PresignExpiry != "" {
+		d, err := time.ParseDuration(req.S3PresignExpiry)
+		if err != nil {
+			return nil, fmt.Errorf("error time.ParseDuration s3_presign_expiry: %w", err)
+		}
+		presignExpiry = d
+	}
+	fileExporter, err := archive.NewS3ExporterWithConfig(ctx, logger, &archive.S3ExporterConfig{
+		Bucket:            req.S3Bucket,
+		FilesKeyPrefix:    req.S3Key,
+		Region:            req.S3Region,
+		Endpoint:          req.S3Endpoint,
+		UsePathStyle:      req.S3UsePathStyle,
+		PresignExpiry:     presignExpiry,
+		BaseURL:           req.S3BaseURL,
+		PartSize:          req.S3PartSizeMB * 1024 * 1024,
+		UploadConcurrency: req.S3UploadConcurrency,
+
+		ServerSideEncryption: req.S3SSE,
+		SSEKMSKeyID:          req.S3SSEKMSKeyID,
+		StorageClass:         req.S3StorageClass,
+		Tags:                 req.S3Tags,
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	var checkpointStore archive.CheckpointStoreInterface
+	if req.Incremental {
+		// NOTE: 空の場合はs3_keyのプレースホルダより前にcheckpoint/{channel}.jsonを置く
+		checkpointStore = fileExporter.CheckpointStore(req.S3CheckpointKey)
+	}
+
+	channels := req.SlackChannels
+	if req.SlackChannel != "" {
+		channels = append([]string{req.SlackChannel}, channels...)
+	}
+
 	conf := &archive.Config{
 		Since:  since,
 		Until:  until,
 		Logger: slog.Default(),
 
-		SlackToken:   req.SlackToken,
-		SlackChannel: req.SlackChannel,
+		SlackToken:             req.SlackToken,
+		SlackChannels:          channels,
+		SlackAllJoinedChannels: req.AllJoinedChannels,
+		SlackHistoryLimit:      req.PageSize,
+		SlackRetrivalLimit:     req.MaxPages,
+		FailOnTruncation:       req.FailOnTruncation,
+		ExcludeSystemMessages:  req.ExcludeSystemMessages,
+		SlackConcurrency:       req.Concurrency,
+		SlackRetry:             retry,
+
+		Incremental:     req.Incremental,
+		CheckpointStore: checkpointStore,
+		ThreadLookback:  threadLookback,
 
 		Formatter:    formatter,
 		TextExporter: textExporter,
@@ -1,12 +1,40 @@
 package main
 
 type archiveRequest struct {
-	SlackToken   string   `json:"slack_token"`
-	SlackChannel string   `json:"slack_channel"`
-	Since        string   `json:"since"`
-	Until        string   `json:"until"`
-	To           []string `json:"to"`
-	Subject      string   `json:"subject"`
-	S3Bucket     string   `json:"s3_bucket"`
-	S3Key        string   `json:"s3_key"`
+	SlackToken            string            `json:"slack_token"`
+	SlackChannel          string            `json:"slack_channel"`
+	SlackChannels         []string          `json:"slack_channels"`
+	AllJoinedChannels     bool              `json:"all_joined_channels"`
+	Since                 string            `json:"since"`
+	Until                 string            `json:"until"`
+	To                    []string          `json:"to"`
+	Subject               string            `json:"subject"`
+	S3Bucket              string            `json:"s3_bucket"`
+	S3Key                 string            `json:"s3_key"`
+	S3Region              string            `json:"s3_region"`
+	S3Endpoint            string            `json:"s3_endpoint"`
+	S3UsePathStyle        bool              `json:"s3_use_path_style"`
+	S3PresignExpiry       string            `json:"s3_presign_expiry"`
+	S3BaseURL             string            `json:"s3_base_url"`
+	S3PartSizeMB          int64             `json:"s3_part_size_mb"`
+	S3UploadConcurrency   int               `json:"s3_upload_concurrency"`
+	S3SSE                 string            `json:"s3_sse"`
+	S3SSEKMSKeyID         string            `json:"s3_sse_kms_key_id"`
+	S3StorageClass        string            `json:"s3_storage_class"`
+	S3Tags                map[string]string `json:"s3_tags"`
+	Incremental           bool              `json:"incremental"`
+	S3CheckpointKey       string            `json:"s3_checkpoint_key"`
+	ThreadLookback        string            `json:"thread_lookback"`
+	RetryMaxAttempts      int               `json:"retry_max_attempts"`
+	PageSize              int               `json:"page_size"`
+	MaxPages              int               `json:"max_pages"`
+	FailOnTruncation      bool              `json:"fail_on_truncation"`
+	Concurrency           int               `json:"concurrency"`
+	ExcludeSystemMessages bool              `json:"exclude_system_messages"`
+	Format                string            `json:"format"`
+	EmbedImages           bool              `json:"embed_images"`
+	HTMLLang              string            `json:"html_lang"`
+	Timezone              string            `json:"timezone"`
+	TimeLayout            string            `json:"time_layout"`
+	DaySeparator          bool              `json:"day_separator"`
 }
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	archive "github.com/ToshihitoKon/slack-archive"
@@ -31,19 +33,58 @@ func main() {
 		conf.logger.Error("config.fileExporter() failed", "error", err)
 		os.Exit(1)
 	}
+	var checkpointStore archive.CheckpointStoreInterface
+	if conf.incremental {
+		checkpointStore, err = conf.checkpointStore(ctx)
+		if err != nil {
+			conf.logger.Error("config.checkpointStore() failed", "error", err)
+			os.Exit(1)
+		}
+	}
 
 	archiveConf := &archive.Config{
 		Since:  conf.since,
 		Until:  conf.until,
 		Logger: conf.logger,
 
-		SlackToken:   archive.Getenv("SLACK_TOKEN"),
-		SlackChannel: archive.Getenv("SLACK_CHANNEL"),
+		SlackToken:             archive.Getenv("SLACK_TOKEN"),
+		SlackChannels:          conf.channels,
+		SlackAllJoinedChannels: conf.allJoinedChannels,
+		SlackHistoryLimit:      conf.pageSize,
+		SlackRetrivalLimit:     conf.maxPages,
+		FailOnTruncation:       conf.failOnTruncation,
+		ExcludeSystemMessages:  conf.excludeSystemMessages,
+		SlackConcurrency:       conf.concurrency,
+		SlackRetry:             conf.retry,
+
+		Incremental:     conf.incremental,
+		CheckpointStore: checkpointStore,
+		ThreadLookback:  conf.threadLookback,
 
 		Formatter:    formatter,
 		TextExporter: textExporter,
 		FileExporter: fileExporter,
 	}
+
+	switch conf.collectorName {
+	case "slack":
+	case "slack-export":
+		exportConf, err := conf.slackExportCollectorConfig()
+		if err != nil {
+			conf.logger.Error("invalid Slack export config", "error", err)
+			os.Exit(1)
+		}
+		collector, err := archive.NewSlackExportCollector(archiveConf, exportConf)
+		if err != nil {
+			conf.logger.Error("archive.NewSlackExportCollector() failed", "error", err)
+			os.Exit(1)
+		}
+		archiveConf.Collector = collector
+	default:
+		conf.logger.Error("Collector is not available.", "collector", conf.collectorName)
+		os.Exit(1)
+	}
+
 	if err := archive.Run(ctx, archiveConf); err != nil {
 		conf.logger.Error("an error occurred", "function", "archive.Run", "error", err.Error())
 		os.Exit(1)
@@ -51,12 +92,27 @@ func main() {
 }
 
 type config struct {
-	since            time.Time
-	until            time.Time
-	formatterName    string
-	textExporterName string
-	fileExporterName string
-	logger           *slog.Logger
+	since                 time.Time
+	until                 time.Time
+	channels              []string
+	allJoinedChannels     bool
+	incremental           bool
+	checkpointName        string
+	threadLookback        time.Duration
+	pageSize              int
+	maxPages              int
+	failOnTruncation      bool
+	excludeSystemMessages bool
+	concurrency           int
+	retry                 *archive.RetryPolicy
+	collectorName         string
+	slackExportPath       string
+	formatterName         string
+	templatePath          string
+	textExporterName      string
+	fileExporterName      string
+	exportErrorPolicy     archive.ExportErrorPolicy
+	logger                *slog.Logger
 }
 
 func newConfig() *config {
@@ -69,14 +125,51 @@ func (c *config) parseFlags() {
 	since := flag.Int64("since", 0, "Archive message since")
 	until := flag.Int64("until", 0, "Archive message until")
 	duration := flag.String("duration", "", "Archive message duration")
-	formatter := flag.String("formatter", "text", "Log format default: text")
-	textExporter := flag.String("text-exporter", "local", "Exporter default: local")
-	fileExporter := flag.String("file-exporter", "local", "Exporter default: local")
+	channels := flag.String("channels", archive.Getenv("SLACK_CHANNEL"), "Comma-separated Slack channel IDs")
+	allJoinedChannels := flag.Bool("all-joined-channels", false, "Archive all public channels the bot is in")
+	incremental := flag.Bool("incremental", false, "Archive messages since the last checkpoint")
+	checkpoint := flag.String("checkpoint", "local", "Checkpoint store default: local")
+	pageSize := flag.Int("page-size", 200, "Number of messages per Slack API page")
+	maxPages := flag.Int("max-pages", 10, "Max pages per channel history or thread. -1 for unlimited")
+	failOnTruncation := flag.Bool("fail-on-truncation", false, "Fail instead of warning when messages remain after max-pages")
+	excludeSystemMessages := flag.Bool("exclude-system-messages", false, "Exclude system messages such as channel joins and topic changes")
+	concurrency := flag.Int("concurrency", 4, "Number of workers fetching replies, user profiles and files")
+	retryMaxAttempts := flag.Int("retry-max-attempts", archive.DefaultRetryPolicy().MaxAttempts, "Max attempts of a Slack API call, including the first one")
+	retryMaxDelay := flag.Duration("retry-max-delay", archive.DefaultRetryPolicy().MaxDelay, "Max backoff delay of Slack API retries")
+	threadLookback := flag.Duration("thread-lookback", archive.DefaultThreadLookback, "How far back threads are checked for new replies in incremental mode. A negative value disables it")
+	collector := flag.String("collector", "slack", "Message source (slack, slack-export) default: slack")
+	slackExportPath := flag.String("slack-export", archive.Getenv("SLACK_EXPORT_PATH"), "Slack export directory or ZIP file of the slack-export collector")
+	formatter := flag.String("formatter", "text", "Log format (text, json, jsonl, html, markdown, slack-export, template) default: text")
+	templatePath := flag.String("template", archive.Getenv("TEMPLATE_FORMATTER_FILE"), "Go template file of the template formatter")
+	textExporter := flag.String("text-exporter", "local", "Comma-separated exporters (none, local, s3, ses) default: local")
+	fileExporter := flag.String("file-exporter", "local", "Comma-separated exporters (none, local, s3) default: local")
+	exportErrorPolicy := flag.String("export-error-policy", "fail-fast", "What to do when one of multiple exporters fails (fail-fast, best-effort) default: fail-fast")
 	flag.Parse()
 
+	c.channels = archive.SplitList(*channels)
+	c.allJoinedChannels = *allJoinedChannels
+	c.incremental = *incremental
+	c.checkpointName = *checkpoint
+	c.threadLookback = *threadLookback
+	c.pageSize = *pageSize
+	c.maxPages = *maxPages
+	c.failOnTruncation = *failOnTruncation
+	c.excludeSystemMessages = *excludeSystemMessages
+	c.concurrency = *concurrency
+	c.retry = archive.DefaultRetryPolicy()
+	c.retry.MaxAttempts = *retryMaxAttempts
+	c.retry.MaxDelay = *retryMaxDelay
+	c.collectorName = *collector
+	c.slackExportPath = *slackExportPath
 	c.formatterName = *formatter
+	c.templatePath = *templatePath
 	c.textExporterName = *textExporter
 	c.fileExporterName = *fileExporter
+	policy, err := archive.ParseExportErrorPolicy(*exportErrorPolicy)
+	if err != nil {
+		panic(err)
+	}
+	c.exportErrorPolicy = policy
 
 	if *duration != "" {
 		if *since != 0 && *until != 0 {
@@ -108,40 +201,205 @@ func (c *config) parseFlags() {
 	}
 }
 
+func (c *config) slackExportCollectorConfig() (*archive.SlackExportCollectorConfig, error) {
+	downloadFiles, err := getenvBool("SLACK_EXPORT_DOWNLOAD_FILES")
+	if err != nil {
+		return nil, err
+	}
+	return &archive.SlackExportCollectorConfig{
+		Path:                  c.slackExportPath,
+		Channels:              c.channels,
+		DownloadFiles:         downloadFiles,
+		Token:                 archive.Getenv("SLACK_TOKEN"),
+		ExcludeSystemMessages: c.excludeSystemMessages,
+		Concurrency:           c.concurrency,
+	}, nil
+}
+
+func s3ExporterConfig() (*archive.S3ExporterConfig, error) {
+	usePathStyle, err := getenvBool("S3_EXPORTER_USE_PATH_STYLE")
+	if err != nil {
+		return nil, err
+	}
+	var presignExpiry time.Duration
+	if v := archive.Getenv("S3_EXPORTER_PRESIGN_EXPIRY"); v != "" {
+		presignExpiry, err = time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("SA_S3_EXPORTER_PRESIGN_EXPIRY: %w", err)
+		}
+	}
+	var partSize int64
+	if v := archive.Getenv("S3_EXPORTER_PART_SIZE_MB"); v != "" {
+		mb, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("SA_S3_EXPORTER_PART_SIZE_MB: %w", err)
+		}
+		partSize = mb * 1024 * 1024
+	}
+	var uploadConcurrency int
+	if v := archive.Getenv("S3_EXPORTER_UPLOAD_CONCURRENCY"); v != "" {
+		uploadConcurrency, err = strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("SA_S3_EXPORTER_UPLOAD_CONCURRENCY: %w", err)
+		}
+	}
+	tags := map[string]string{}
+	for _, kv := range archive.SplitList(archive.Getenv("S3_EXPORTER_TAGS")) {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("SA_S3_EXPORTER_TAGS: %s is not key=value", kv)
+		}
+		tags[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return &archive.S3ExporterConfig{
+		Bucket:            archive.Getenv("S3_EXPORTER_BUCKET"),
+		ArchiveFilename:   archive.Getenv("S3_EXPORTER_ARCHIVE_FILENAME"),
+		FilesKeyPrefix:    archive.Getenv("S3_EXPORTER_FILES_KEY_PREFIX"),
+		Region:            archive.Getenv("S3_EXPORTER_REGION"),
+		Endpoint:          archive.Getenv("S3_EXPORTER_ENDPOINT"),
+		UsePathStyle:      usePathStyle,
+		PresignExpiry:     presignExpiry,
+		BaseURL:           archive.Getenv("S3_EXPORTER_BASE_URL"),
+		PartSize:          partSize,
+		UploadConcurrency: uploadConcurrency,
+
+		ServerSideEncryption: archive.Getenv("S3_EXPORTER_SSE"),
+		SSEKMSKeyID:          archive.Getenv("S3_EXPORTER_SSE_KMS_KEY_ID"),
+		StorageClass:         archive.Getenv("S3_EXPORTER_STORAGE_CLASS"),
+		Tags:                 tags,
+	}, nil
+}
+
 func (c *config) formatter() (archive.FormatterInterface, error) {
+	// NOTE: SA_TEXT_FORMATTER_TIMEZONE等は全てのFormatterに効く
+	timeFormat, err := archive.NewTimeFormat(archive.Getenv("TEXT_FORMATTER_TIMEZONE"), archive.Getenv("TEXT_FORMATTER_TIME_LAYOUT"))
+	if err != nil {
+		return nil, fmt.Errorf("SA_TEXT_FORMATTER_TIMEZONE: %w", err)
+	}
+	daySeparator, err := getenvBool("TEXT_FORMATTER_DAY_SEPARATOR")
+	if err != nil {
+		return nil, err
+	}
+
 	var formatter archive.FormatterInterface
 	switch c.formatterName {
 	case "text":
 		indent := archive.Getenv("TEXT_FORMATTER_REPLY_INDENT")
-		formatter = archive.NewTextFormatter(indent)
+		f := archive.NewTextFormatter(indent)
+		f.TimeFormat = timeFormat
+		f.DaySeparator = daySeparator
+		formatter = f
+	case "json", "jsonl":
+		flatten, err := getenvBool("JSON_FORMATTER_FLATTEN_REPLIES")
+		if err != nil {
+			return nil, err
+		}
+		if c.formatterName == "json" {
+			f := archive.NewJSONFormatter(flatten)
+			f.TimeFormat = timeFormat
+			formatter = f
+		} else {
+			f := archive.NewJSONLFormatter(flatten)
+			f.TimeFormat = timeFormat
+			formatter = f
+		}
+	case "html":
+		embedImages, err := getenvBool("HTML_FORMATTER_EMBED_IMAGES")
+		if err != nil {
+			return nil, err
+		}
+		f := archive.NewHTMLFormatter(c.logger, embedImages)
+		f.TimeFormat = timeFormat
+		f.DaySeparator = daySeparator
+		if lang := archive.Getenv("HTML_FORMATTER_LANG"); lang != "" {
+			f.Lang = lang
+		}
+		formatter = f
+	case "markdown":
+		f := archive.NewMarkdownFormatter()
+		f.TimeFormat = timeFormat
+		formatter = f
+	case "slack-export":
+		f := archive.NewSlackExportFormatter()
+		f.TimeFormat = timeFormat
+		formatter = f
+	case "template":
+		f, err := archive.NewTemplateFormatter(c.templatePath)
+		if err != nil {
+			return nil, err
+		}
+		f.TimeFormat = timeFormat
+		formatter = f
 	default:
 		return nil, fmt.Errorf("Formatter is not available. FormatterName: %s", c.formatterName)
 	}
 	return formatter, nil
 }
 
+// getenvBool returns false if the env is not set.
+func getenvBool(name string) (bool, error) {
+	v := archive.Getenv(name)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("SA_%s: %w", name, err)
+	}
+	return b, nil
+}
+
+// textExporter builds exporters from comma-separated names such as "s3,ses".
 func (c *config) textExporter(ctx context.Context) (archive.TextExporterInterface, error) {
+	names := archive.SplitList(c.textExporterName)
+	if len(names) == 0 {
+		return nil, fmt.Errorf("--text-exporter is empty")
+	}
+	if len(names) == 1 {
+		return c.newTextExporter(ctx, names[0])
+	}
+	exporters := []archive.TextExporterInterface{}
+	for _, name := range names {
+		exp, err := c.newTextExporter(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		exporters = append(exporters, exp)
+	}
+	return archive.NewMultiTextExporter(c.exportErrorPolicy, exporters...), nil
+}
+
+func (c *config) newTextExporter(ctx context.Context, name string) (archive.TextExporterInterface, error) {
 	var textExporter archive.TextExporterInterface
-	switch c.textExporterName {
+	switch name {
 	case "none":
 		exp := &archive.NoneExporter{}
 		textExporter = exp
 	case "local":
-		logPath := archive.Getenv("LOCAL_EXPORTER_LOGFILE")
-		fileDir := archive.Getenv("LOCAL_EXPORTER_FILEDIR")
-		exp := archive.NewLocalExporter(c.logger, logPath, fileDir)
+		exp, err := c.localExporter()
+		if err != nil {
+			return nil, err
+		}
+		// NOTE: ZIPを追記するとファイルが壊れる
+		if c.formatterName == "slack-export" && exp.WriteMode == archive.LocalWriteAppend {
+			return nil, fmt.Errorf("--formatter slack-export writes a ZIP file, which SA_LOCAL_EXPORTER_WRITE_MODE=append would corrupt")
+		}
 		textExporter = exp
 	case "s3":
-		exp, err := archive.NewS3Exporter(ctx, c.logger,
-			archive.Getenv("S3_EXPORTER_BUCKET"),
-			archive.Getenv("S3_EXPORTER_ARCHIVE_FILENAME"),
-			archive.Getenv("S3_EXPORTER_FILES_KEY_PREFIX"),
-		)
+		s3Conf, err := s3ExporterConfig()
+		if err != nil {
+			return nil, err
+		}
+		exp, err := archive.NewS3ExporterWithConfig(ctx, c.logger, s3Conf)
 		if err != nil {
 			return nil, err
 		}
 		textExporter = exp
 	case "ses":
+		// NOTE: SESは出力をメール本文として送るので、ZIPは送れない
+		if c.formatterName == "slack-export" {
+			return nil, fmt.Errorf("--formatter slack-export writes a ZIP file, which --text-exporter ses cannot send as a mail body")
+		}
 		var (
 			configSetName = archive.Getenv("SES_EXPORTER_CONFIG_SET_NAME")
 			sourceArn     = archive.Getenv("SES_EXPORTER_SOURCE_ARN")
@@ -161,36 +419,103 @@ func (c *config) textExporter(ctx context.Context) (archive.TextExporterInterfac
 		}
 		textExporter = exp
 	default:
-		return nil, fmt.Errorf("TextExporter %s is not available", c.textExporterName)
+		return nil, fmt.Errorf("TextExporter %s is not available", name)
 	}
 
 	return textExporter, nil
 }
 
+// fileExporter builds exporters from comma-separated names such as "s3,local".
+// File names in the text come from the first one.
 func (c *config) fileExporter(ctx context.Context) (archive.FileExporterInterface, error) {
+	names := archive.SplitList(c.fileExporterName)
+	if len(names) == 0 {
+		return nil, fmt.Errorf("--file-exporter is empty")
+	}
+	if len(names) == 1 {
+		return c.newFileExporter(ctx, names[0])
+	}
+	exporters := []archive.FileExporterInterface{}
+	for _, name := range names {
+		exp, err := c.newFileExporter(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		exporters = append(exporters, exp)
+	}
+	return archive.NewMultiFileExporter(c.exportErrorPolicy, exporters...), nil
+}
+
+func (c *config) newFileExporter(ctx context.Context, name string) (archive.FileExporterInterface, error) {
 	var fileExporter archive.FileExporterInterface
-	switch c.fileExporterName {
+	switch name {
 	case "none":
 		exp := &archive.NoneExporter{}
 		fileExporter = exp
 	case "local":
-		logPath := archive.Getenv("LOCAL_EXPORTER_LOGFILE")
-		fileDir := archive.Getenv("LOCAL_EXPORTER_FILEDIR")
-		exp := archive.NewLocalExporter(c.logger, logPath, fileDir)
+		exp, err := c.localExporter()
+		if err != nil {
+			return nil, err
+		}
 		fileExporter = exp
 	case "s3":
-		exp, err := archive.NewS3Exporter(ctx, c.logger,
-			archive.Getenv("S3_EXPORTER_BUCKET"),
-			archive.Getenv("S3_EXPORTER_ARCHIVE_FILENAME"),
-			archive.Getenv("S3_EXPORTER_FILES_KEY_PREFIX"),
-		)
+		s3Conf, err := s3ExporterConfig()
+		if err != nil {
+			return nil, err
+		}
+		exp, err := archive.NewS3ExporterWithConfig(ctx, c.logger, s3Conf)
 		if err != nil {
 			return nil, err
 		}
 		fileExporter = exp
 	default:
-		return nil, fmt.Errorf("File exporter %s is not available", c.fileExporterName)
+		return nil, fmt.Errorf("File exporter %s is not available", name)
 	}
 
 	return fileExporter, nil
 }
+
+func (c *config) localExporter() (*archive.LocalExporter, error) {
+	logPath := archive.Getenv("LOCAL_EXPORTER_LOGFILE")
+	fileDir := archive.Getenv("LOCAL_EXPORTER_FILEDIR")
+	exp := archive.NewLocalExporter(c.logger, logPath, fileDir)
+	if v := archive.Getenv("LOCAL_EXPORTER_WRITE_MODE"); v != "" {
+		mode, err := archive.ParseLocalWriteMode(v)
+		if err != nil {
+			return nil, fmt.Errorf("SA_LOCAL_EXPORTER_WRITE_MODE: %w", err)
+		}
+		exp.WriteMode = mode
+	}
+	// NOTE: per-runの {run} と {channel} はRunがアーカイブを始める前に確かめる
+	// NOTE: 環境変数に改行を入れにくいので、"\n" は改行にする
+	if v := archive.Getenv("LOCAL_EXPORTER_SEPARATOR"); v != "" {
+		exp.Separator = strings.ReplaceAll(v, `\n`, "\n")
+	}
+	return exp, nil
+}
+
+func (c *config) checkpointStore(ctx context.Context) (archive.CheckpointStoreInterface, error) {
+	var store archive.CheckpointStoreInterface
+	switch c.checkpointName {
+	case "local":
+		filePath := archive.Getenv("LOCAL_CHECKPOINT_FILE")
+		if filePath == "" {
+			return nil, fmt.Errorf("SA_LOCAL_CHECKPOINT_FILE is required")
+		}
+		store = archive.NewLocalCheckpointStore(c.logger, filePath)
+	case "s3":
+		s3Conf, err := s3ExporterConfig()
+		if err != nil {
+			return nil, err
+		}
+		exp, err := archive.NewS3ExporterWithConfig(ctx, c.logger, s3Conf)
+		if err != nil {
+			return nil, err
+		}
+		store = exp.CheckpointStore(archive.Getenv("S3_CHECKPOINT_KEY"))
+	default:
+		return nil, fmt.Errorf("Checkpoint store %s is not available", c.checkpointName)
+	}
+
+	return store, nil
+}
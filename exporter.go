@@ -2,11 +2,15 @@ package archive
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"os"
 	"path"
+	"strings"
+	"time"
 )
 
 type NoneExporter struct{}
@@ -14,23 +18,58 @@ type NoneExporter struct{}
 var _ TextExporterInterface = (*NoneExporter)(nil)
 var _ FileExporterInterface = (*NoneExporter)(nil)
 
-func (_ *NoneExporter) Write(_ context.Context, _ []byte) error { return nil }
-func (_ *NoneExporter) WriteFiles(_ context.Context, _ []*LocalFile) error {
+func (_ *NoneExporter) Write(_ context.Context, _ *Target, _ []byte) error { return nil }
+func (_ *NoneExporter) WriteFiles(_ context.Context, _ *Target, _ []*LocalFile) error {
 	return nil
 }
-func (_ *NoneExporter) FormatFileName(f *LocalFile) string {
+func (_ *NoneExporter) FormatFileName(_ *Target, f *LocalFile) string {
 	return ""
 }
 
+// LocalWriteMode decides how LocalExporter writes to an existing log file.
+type LocalWriteMode int
+
+const (
+	// LocalWriteTruncate replaces the file with the new archive.
+	LocalWriteTruncate LocalWriteMode = iota
+	// LocalWriteAppend adds the new archive after the separator.
+	// The whole file is read and rewritten on each write to keep it atomic, so rotate large files by {yyyy} or {mm}.
+	LocalWriteAppend
+	// LocalWritePerRun writes a new file per run. The log file path must contain {run}, which Run checks.
+	// It fails if the file already exists.
+	LocalWritePerRun
+)
+
+// ParseLocalWriteMode parses "truncate", "append" or "per-run".
+func ParseLocalWriteMode(s string) (LocalWriteMode, error) {
+	switch s {
+	case "truncate":
+		return LocalWriteTruncate, nil
+	case "append":
+		return LocalWriteAppend, nil
+	case "per-run":
+		return LocalWritePerRun, nil
+	}
+	return LocalWriteTruncate, fmt.Errorf("unknown local write mode: %s", s)
+}
+
 type LocalExporter struct {
 	logFilePath string
 	fileDirPath string
 
+	WriteMode LocalWriteMode
+	// Separator is put between archives in LocalWriteAppend.
+	Separator string
+
+	// runTime fills {run} in the log file path.
+	runTime time.Time
+
 	logger *slog.Logger
 }
 
 var _ TextExporterInterface = (*LocalExporter)(nil)
 var _ FileExporterInterface = (*LocalExporter)(nil)
+var _ FileExistsInterface = (*LocalExporter)(nil)
 
 func NewLocalExporter(logger *slog.Logger, logPath, fileDirPath string) *LocalExporter {
 	if logPath == "" || fileDirPath == "" {
@@ -40,12 +79,66 @@ func NewLocalExporter(logger *slog.Logger, logPath, fileDirPath string) *LocalEx
 	return &LocalExporter{
 		logFilePath: logPath,
 		fileDirPath: fileDirPath,
+		Separator:   "\n",
+		runTime:     time.Now(),
 		logger:      logger,
 	}
 }
 
-func (e *LocalExporter) Write(ctx context.Context, data []byte) error {
-	f, err := os.OpenFile(e.logFilePath, os.O_WRONLY|os.O_CREATE, 0644)
+func (e *LocalExporter) Write(ctx context.Context, target *Target, data []byte) error {
+	logFilePath := strings.ReplaceAll(expandTemplate(e.logFilePath, target), "{run}", e.runTime.Format(templateTimeLayout))
+	if err := os.MkdirAll(path.Dir(logFilePath), 0755); err != nil {
+		return err
+	}
+
+	// NOTE: /dev/stdoutなどの通常ファイル以外はrenameできないので、そのまま書く
+	stat, err := os.Stat(logFilePath)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return err
+	case !stat.Mode().IsRegular():
+		return e.writeDirect(logFilePath, data)
+	case e.WriteMode == LocalWritePerRun:
+		return fmt.Errorf("%s already exists", logFilePath)
+	case e.WriteMode == LocalWriteAppend:
+		old, err := os.ReadFile(logFilePath)
+		if err != nil {
+			return err
+		}
+		if len(old) != 0 {
+			data = append(append(old, e.Separator...), data...)
+		}
+	}
+
+	if err := writeFileAtomic(logFilePath, data); err != nil {
+		return err
+	}
+	e.logger.Info(fmt.Sprintf("LocalExporter: Write success. file: %s", logFilePath))
+	return nil
+}
+
+// validateDestination rejects LocalWritePerRun without {run}, which would fail from the second run.
+func (e *LocalExporter) validateDestination() error {
+	if e.WriteMode == LocalWritePerRun && !strings.Contains(e.logFilePath, "{run}") {
+		return fmt.Errorf("LocalExporter: the log file path %s needs {run} in per-run mode", e.logFilePath)
+	}
+	return nil
+}
+
+// sharedDestination allows a fixed path in append mode and for non-regular files such as /dev/stdout.
+func (e *LocalExporter) sharedDestination() string {
+	if hasChannelPlaceholder(e.logFilePath) || e.WriteMode == LocalWriteAppend {
+		return ""
+	}
+	if stat, err := os.Stat(e.logFilePath); err == nil && !stat.Mode().IsRegular() {
+		return ""
+	}
+	return e.logFilePath
+}
+
+func (e *LocalExporter) writeDirect(filePath string, data []byte) error {
+	f, err := os.OpenFile(filePath, os.O_WRONLY, 0)
 	if err != nil {
 		return err
 	}
@@ -54,20 +147,44 @@ func (e *LocalExporter) Write(ctx context.Context, data []byte) error {
 	if _, err := f.Write(data); err != nil {
 		return err
 	}
-	e.logger.Info(fmt.Sprintf("LocalExporter: Write success. file: %s", e.logFilePath))
+	e.logger.Info(fmt.Sprintf("LocalExporter: Write success. file: %s", filePath))
 	return nil
 }
 
-func (e *LocalExporter) WriteFiles(ctx context.Context, files []*LocalFile) error {
-	if _, err := os.ReadDir(e.fileDirPath); err != nil {
-		if err := os.MkdirAll(e.fileDirPath, 0755); err != nil {
-			return err
-		}
+// writeFileAtomic writes data to a temporary file in the same directory and renames it,
+// so a crash never leaves a half-written file.
+func writeFileAtomic(filePath string, data []byte) error {
+	tmp, err := os.CreateTemp(path.Dir(filePath), "."+path.Base(filePath)+".tmp*")
+	if err != nil {
+		return err
 	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filePath)
+}
+
+func (e *LocalExporter) WriteFiles(ctx context.Context, target *Target, files []*LocalFile) error {
 	for _, file := range files {
-		filename := fmt.Sprintf("%s_%s", file.id, file.name)
 		srcPath := file.path
-		dstPath := path.Join(e.fileDirPath, filename)
+		dstPath := filePath(e.fileDirPath, target, file)
+		// NOTE: ファイルの日付でディレクトリが変わるので、ファイルごとに作る
+		if err := os.MkdirAll(path.Dir(dstPath), 0755); err != nil {
+			return err
+		}
 		e.logger.Info("WriteFile copy", "source", srcPath, "destination", dstPath)
 		if err := copy(srcPath, dstPath); err != nil {
 			return err
@@ -77,8 +194,22 @@ func (e *LocalExporter) WriteFiles(ctx context.Context, files []*LocalFile) erro
 	return nil
 }
 
-func (e *LocalExporter) FormatFileName(f *LocalFile) string {
-	return fmt.Sprintf("%s_%s", f.id, f.name)
+// FormatFileName returns the path relative to the file directory.
+// If the directory is a template, the expanded part such as "2024/07" is included.
+func (e *LocalExporter) FormatFileName(target *Target, f *LocalFile) string {
+	return fileRelPath(e.fileDirPath, target, f)
+}
+
+func (e *LocalExporter) Exists(ctx context.Context, target *Target, f *LocalFile) (bool, error) {
+	stat, err := os.Stat(filePath(e.fileDirPath, target, f))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	// NOTE: コピーが途中で止まったファイルはサイズが違うので、もう一度書く
+	return stat.Mode().IsRegular() && (f.size == 0 || stat.Size() == f.size), nil
 }
 
 func copy(srcPath, dstPath string) error {
@@ -0,0 +1,113 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ExportErrorPolicy decides what multi exporters do when one of the exporters fails.
+type ExportErrorPolicy int
+
+const (
+	// FailFast stops at the first failed exporter.
+	FailFast ExportErrorPolicy = iota
+	// BestEffort runs all exporters and returns the joined errors.
+	BestEffort
+)
+
+// ParseExportErrorPolicy parses "fail-fast" or "best-effort".
+func ParseExportErrorPolicy(s string) (ExportErrorPolicy, error) {
+	switch s {
+	case "fail-fast":
+		return FailFast, nil
+	case "best-effort":
+		return BestEffort, nil
+	}
+	return FailFast, fmt.Errorf("unknown export error policy: %s", s)
+}
+
+// MultiTextExporter writes the same text to all exporters in order.
+type MultiTextExporter struct {
+	exporters []TextExporterInterface
+	policy    ExportErrorPolicy
+}
+
+var _ TextExporterInterface = (*MultiTextExporter)(nil)
+
+func NewMultiTextExporter(policy ExportErrorPolicy, exporters ...TextExporterInterface) *MultiTextExporter {
+	return &MultiTextExporter{
+		exporters: exporters,
+		policy:    policy,
+	}
+}
+
+func (e *MultiTextExporter) Write(ctx context.Context, target *Target, data []byte) error {
+	var errs []error
+	for _, exporter := range e.exporters {
+		if err := exporter.Write(ctx, target, data); err != nil {
+			err = fmt.Errorf("%T: %w", exporter, err)
+			if e.policy == FailFast {
+				return err
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// MultiFileExporter writes the same files to all exporters in order.
+// FormatFileName uses the first exporter, so put the exporter whose names should appear in the text first.
+type MultiFileExporter struct {
+	exporters []FileExporterInterface
+	policy    ExportErrorPolicy
+}
+
+var _ FileExporterInterface = (*MultiFileExporter)(nil)
+var _ FileExistsInterface = (*MultiFileExporter)(nil)
+
+func NewMultiFileExporter(policy ExportErrorPolicy, exporters ...FileExporterInterface) *MultiFileExporter {
+	if len(exporters) == 0 {
+		panic("NewMultiFileExporter: exporters are required")
+	}
+	return &MultiFileExporter{
+		exporters: exporters,
+		policy:    policy,
+	}
+}
+
+func (e *MultiFileExporter) WriteFiles(ctx context.Context, target *Target, files []*LocalFile) error {
+	var errs []error
+	for _, exporter := range e.exporters {
+		if err := exporter.WriteFiles(ctx, target, files); err != nil {
+			err = fmt.Errorf("%T: %w", exporter, err)
+			if e.policy == FailFast {
+				return err
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Exists reports true only if all exporters can check and have the file.
+func (e *MultiFileExporter) Exists(ctx context.Context, target *Target, f *LocalFile) (bool, error) {
+	for _, exporter := range e.exporters {
+		checker, ok := exporter.(FileExistsInterface)
+		if !ok {
+			return false, nil
+		}
+		exists, err := checker.Exists(ctx, target, f)
+		if err != nil {
+			return false, fmt.Errorf("%T: %w", exporter, err)
+		}
+		if !exists {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (e *MultiFileExporter) FormatFileName(target *Target, f *LocalFile) string {
+	return e.exporters[0].FormatFileName(target, f)
+}
@@ -4,10 +4,78 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"time"
 )
 
+// DefaultTimeLayout is the timestamp layout of formatters.
+const DefaultTimeLayout = "2006/01/02 15:04:05"
+
+// TimeFormat is how formatters print timestamps.
+// NOTE: Lambda(UTC)とローカル(JST)で同じ出力になるように、タイムゾーンを明示できる
+type TimeFormat struct {
+	// Location is the timezone of timestamps. nil means the local timezone of the process.
+	Location *time.Location
+	// Layout is the layout of timestamps. Empty means DefaultTimeLayout.
+	Layout string
+}
+
+// NewTimeFormat loads the IANA timezone such as "Asia/Tokyo". Empty values mean the defaults.
+func NewTimeFormat(timezone, layout string) (TimeFormat, error) {
+	tf := TimeFormat{Layout: layout}
+	if timezone != "" {
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			return TimeFormat{}, err
+		}
+		tf.Location = loc
+	}
+	return tf, nil
+}
+
+func (tf TimeFormat) inLocation(t time.Time) time.Time {
+	if tf.Location == nil {
+		return t.Local()
+	}
+	return t.In(tf.Location)
+}
+
+func (tf TimeFormat) formatTime(t time.Time) string {
+	layout := tf.Layout
+	if layout == "" {
+		layout = DefaultTimeLayout
+	}
+	return tf.inLocation(t).Format(layout)
+}
+
+// timeFormat makes formatters embedding TimeFormat implement timeFormatter.
+func (tf TimeFormat) timeFormat() TimeFormat {
+	return tf
+}
+
+// timeFormatter is implemented by formatters embedding TimeFormat.
+type timeFormatter interface {
+	timeFormat() TimeFormat
+}
+
+// formatterTimeFormat returns the TimeFormat of the formatter, or the default.
+// NOTE: Block Kitの日付はコレクタで文字列にするので、Formatterの設定を渡してメッセージの日時と揃える
+func formatterTimeFormat(f FormatterInterface) TimeFormat {
+	if tf, ok := f.(timeFormatter); ok {
+		return tf.timeFormat()
+	}
+	return TimeFormat{}
+}
+
+// formatDay returns the date of t for day separators.
+func (tf TimeFormat) formatDay(t time.Time) string {
+	return tf.inLocation(t).Format("2006/01/02 (Mon)")
+}
+
 type TextFormatter struct {
 	ReplyIndent string
+	TimeFormat
+	// DaySeparator puts a header line when the date changes.
+	DaySeparator bool
 }
 
 var _ FormatterInterface = (*TextFormatter)(nil)
@@ -18,38 +86,121 @@ func NewTextFormatter(replyIndent string) *TextFormatter {
 	}
 }
 
-func (f *TextFormatter) Format(outputs Outputs, writeFileName func(*LocalFile) string) []byte {
+func (f *TextFormatter) Format(_ *Target, outputs Outputs, writeFileName func(*LocalFile) string) []byte {
 	sort.Slice(outputs, func(i, j int) bool { return outputs[i].Timestamp.Before(outputs[j].Timestamp) })
 
 	texts := []string{}
+	var day string
 	for _, output := range outputs {
+		if d := f.formatDay(output.Timestamp); f.DaySeparator && d != day {
+			day = d
+			texts = append(texts, fmt.Sprintf("---------- %s ----------", day))
+		}
+
 		text := fmt.Sprintf(
 			"[%s] [%s] %s",
-			output.Timestamp.Format("2006/01/02 15:04:05"),
+			f.formatTime(output.Timestamp),
 			output.Username,
-			output.Text,
+			formatMessageText(output, messageBody(output), f.TimeFormat),
 		)
 		for _, tfile := range output.LocalFiles {
 			text += fmt.Sprintf("\n(file: %s)", writeFileName(tfile))
 		}
+		for _, reaction := range output.Reactions {
+			text += fmt.Sprintf("\n%s", formatReaction(reaction))
+		}
 		texts = append(texts, text)
 
 		// replies
 		sort.Slice(output.Replies, func(i, j int) bool { return output.Replies[i].Timestamp.Before(output.Replies[j].Timestamp) })
 		for _, reply := range output.Replies {
-			textBody := strings.ReplaceAll(reply.Text, "\n", fmt.Sprintf("\n%s", f.ReplyIndent))
+			textBody := strings.ReplaceAll(formatMessageText(reply, messageBody(reply), f.TimeFormat), "\n", fmt.Sprintf("\n%s", f.ReplyIndent))
 			text := fmt.Sprintf(
 				"%s[%s] [%s] %s",
 				f.ReplyIndent,
-				reply.Timestamp.Format("2006/01/02 15:04:05"),
+				f.formatTime(reply.Timestamp),
 				reply.Username,
 				textBody,
 			)
 			for _, tfile := range reply.LocalFiles {
 				text += fmt.Sprintf("\n%s(file: %s)", f.ReplyIndent, writeFileName(tfile))
 			}
+			for _, reaction := range reply.Reactions {
+				text += fmt.Sprintf("\n%s%s", f.ReplyIndent, formatReaction(reaction))
+			}
 			texts = append(texts, text)
 		}
 	}
 	return []byte(strings.Join(texts, "\n"))
 }
+
+func formatReaction(r *Reaction) string {
+	if len(r.Users) == 0 {
+		return fmt.Sprintf("(reaction: :%s: %d)", r.Name, r.Count)
+	}
+	return fmt.Sprintf("(reaction: :%s: %d %s)", r.Name, r.Count, strings.Join(r.Users, ", "))
+}
+
+// formatMessageText marks deleted and edited messages.
+func formatMessageText(o *Output, text string, tf TimeFormat) string {
+	if o.Deleted {
+		text = "[deleted] " + text
+	}
+	if o.Edited != nil {
+		text += fmt.Sprintf(" (edited %s by %s)", tf.formatTime(o.Edited.Timestamp), o.Edited.Username)
+	}
+	return text
+}
+
+// messageBody returns blocks instead of Text if any, as Slack clients do, followed by attachments.
+func messageBody(o *Output) string {
+	lines := []string{}
+	if len(o.Blocks) != 0 {
+		for _, b := range o.Blocks {
+			lines = append(lines, blockLines(b)...)
+		}
+	} else {
+		lines = append(lines, o.Text)
+	}
+
+	for _, a := range o.Attachments {
+		if a.Pretext != "" {
+			lines = append(lines, a.Pretext)
+		}
+		attachmentLines := []string{}
+		switch {
+		case a.Title != "" && a.TitleLink != "":
+			attachmentLines = append(attachmentLines, fmt.Sprintf("%s (%s)", a.Title, a.TitleLink))
+		case a.Title != "":
+			attachmentLines = append(attachmentLines, a.Title)
+		}
+		if a.Text != "" {
+			attachmentLines = append(attachmentLines, a.Text)
+		}
+		for _, field := range a.Fields {
+			attachmentLines = append(attachmentLines, fmt.Sprintf("%s: %s", field.Title, field.Value))
+		}
+		for _, b := range a.Blocks {
+			attachmentLines = append(attachmentLines, blockLines(b)...)
+		}
+		if a.Footer != "" {
+			attachmentLines = append(attachmentLines, a.Footer)
+		}
+		if len(attachmentLines) == 0 {
+			continue
+		}
+		for _, line := range strings.Split(strings.Join(attachmentLines, "\n"), "\n") {
+			lines = append(lines, "| "+line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func blockLines(b *Block) []string {
+	lines := []string{}
+	if b.Text != "" {
+		lines = append(lines, b.Text)
+	}
+	lines = append(lines, b.Fields...)
+	return lines
+}
@@ -0,0 +1,224 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// HTMLFormatter renders a standalone HTML page with collapsed threads.
+type HTMLFormatter struct {
+	TimeFormat
+	// DaySeparator puts a header when the date changes.
+	DaySeparator bool
+	// EmbedImages inlines image files as data URIs instead of linking them by FormatFileName.
+	// NOTE: メール受信者はS3のURLを開けないことがあるので、SESで送る場合は埋め込む
+	EmbedImages bool
+	// Lang is the lang attribute of the page such as "ja" or "en". Empty omits it.
+	Lang string
+
+	logger *slog.Logger
+}
+
+var _ FormatterInterface = (*HTMLFormatter)(nil)
+var _ FormatterWithErrorInterface = (*HTMLFormatter)(nil)
+var _ fileContentFormatter = (*HTMLFormatter)(nil)
+
+// needsFileContent keeps SlackCollector downloading files which the file exporter already has.
+func (f *HTMLFormatter) needsFileContent() bool {
+	return f.EmbedImages
+}
+
+func NewHTMLFormatter(logger *slog.Logger, embedImages bool) *HTMLFormatter {
+	return &HTMLFormatter{
+		EmbedImages: embedImages,
+		Lang:        "ja",
+		logger:      logger,
+	}
+}
+
+type htmlPage struct {
+	Lang     string
+	Title    string
+	Messages []*htmlMessage
+}
+
+type htmlMessage struct {
+	// Day is set on the first message of the day if DaySeparator is enabled.
+	Day       string
+	Username  string
+	Avatar    string
+	Initial   string
+	Time      string
+	Permalink string
+	Body      string
+	Files     []*htmlFile
+	Reactions []*Reaction
+	Replies   []*htmlMessage
+}
+
+type htmlFile struct {
+	Name  string
+	URL   string
+	Image bool
+	// DataURI is the embedded image. Only data URIs made by dataURI skip the URL sanitization of html/template.
+	DataURI template.URL
+}
+
+func (f *HTMLFormatter) Format(target *Target, outputs Outputs, writeFileName func(*LocalFile) string) []byte {
+	b, err := f.FormatE(target, outputs, writeFileName)
+	if err != nil {
+		f.logger.Error("an error occurred", "function", "HTMLFormatter.Format", "channel", target.ChannelID, "error", err.Error())
+		return nil
+	}
+	return b
+}
+
+func (f *HTMLFormatter) FormatE(target *Target, outputs Outputs, writeFileName func(*LocalFile) string) ([]byte, error) {
+	sort.Slice(outputs, func(i, j int) bool { return outputs[i].Timestamp.Before(outputs[j].Timestamp) })
+
+	page := &htmlPage{
+		Lang: f.Lang,
+		Title: fmt.Sprintf("#%s %s - %s",
+			target.ChannelName,
+			f.formatTime(target.Since),
+			f.formatTime(target.Until),
+		),
+		Messages: []*htmlMessage{},
+	}
+	var day string
+	for _, output := range outputs {
+		message := f.message(output, writeFileName)
+		if d := f.formatDay(output.Timestamp); f.DaySeparator && d != day {
+			day = d
+			message.Day = day
+		}
+		sort.Slice(output.Replies, func(i, j int) bool { return output.Replies[i].Timestamp.Before(output.Replies[j].Timestamp) })
+		for _, reply := range output.Replies {
+			message.Replies = append(message.Replies, f.message(reply, writeFileName))
+		}
+		page.Messages = append(page.Messages, message)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := htmlPageTemplate.Execute(buf, page); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (f *HTMLFormatter) message(output *Output, writeFileName func(*LocalFile) string) *htmlMessage {
+	message := &htmlMessage{
+		Username:  output.Username,
+		Avatar:    output.UserAvatar,
+		Time:      f.formatTime(output.Timestamp),
+		Permalink: output.Permalink,
+		Body:      formatMessageText(output, messageBody(output), f.TimeFormat),
+		Files:     []*htmlFile{},
+		Reactions: output.Reactions,
+	}
+	if r, _ := utf8.DecodeRuneInString(output.Username); r != utf8.RuneError {
+		message.Initial = strings.ToUpper(string(r))
+	}
+
+	for _, lf := range output.LocalFiles {
+		file := &htmlFile{
+			Name: lf.name,
+			URL:  writeFileName(lf),
+		}
+		if ctype, err := lf.detectContentType(); err == nil && strings.HasPrefix(ctype, "image/") {
+			file.Image = true
+			if f.EmbedImages {
+				if src, err := dataURI(lf.path, ctype); err == nil {
+					file.DataURI = src
+				} else {
+					f.logger.Warn("failed to embed the image. It is linked instead.", "file", lf.id, "error", err.Error())
+				}
+			}
+		}
+		message.Files = append(message.Files, file)
+	}
+	return message
+}
+
+func dataURI(filePath, contentType string) (template.URL, error) {
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", err
+	}
+	return template.URL("data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(b)), nil
+}
+
+var htmlPageTemplate = template.Must(template.New("archive").Funcs(template.FuncMap{
+	"join": strings.Join,
+}).Parse(`<!DOCTYPE html>
+<html{{ if .Lang }} lang="{{ .Lang }}"{{ end }}>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{ .Title }}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", "Hiragino Sans", Meiryo, sans-serif; color: #1d1c1d; margin: 0 auto; max-width: 860px; padding: 16px; }
+h1 { font-size: 18px; border-bottom: 1px solid #ddd; padding-bottom: 8px; }
+h2 { font-size: 14px; color: #616061; border-bottom: 1px solid #eee; padding-bottom: 4px; }
+.message { display: flex; gap: 8px; padding: 8px 0; }
+.avatar { width: 36px; height: 36px; border-radius: 4px; flex-shrink: 0; }
+.initial { background: #888; color: #fff; text-align: center; line-height: 36px; font-weight: bold; }
+.content { min-width: 0; flex-grow: 1; }
+.username { font-weight: bold; }
+.time, .time a { color: #616061; font-size: 12px; margin-left: 4px; }
+.body { white-space: pre-wrap; word-wrap: break-word; margin: 2px 0; }
+.file img { max-width: 360px; max-height: 360px; border: 1px solid #ddd; border-radius: 4px; }
+.reaction { display: inline-block; background: #f0f0f0; border-radius: 12px; font-size: 12px; padding: 2px 8px; margin: 2px 4px 0 0; }
+details { margin-top: 4px; }
+summary { color: #1264a3; cursor: pointer; font-size: 13px; }
+.replies { border-left: 3px solid #ddd; padding-left: 12px; }
+</style>
+</head>
+<body>
+<h1>{{ .Title }}</h1>
+{{- range .Messages }}
+{{- if .Day }}
+<h2>{{ .Day }}</h2>
+{{- end }}
+{{ template "message" . }}
+{{- end }}
+</body>
+</html>
+{{ define "message" -}}
+<div class="message">
+{{- if .Avatar }}
+<img class="avatar" src="{{ .Avatar }}" alt="">
+{{- else }}
+<div class="avatar initial">{{ .Initial }}</div>
+{{- end }}
+<div class="content">
+<div><span class="username">{{ .Username }}</span>
+{{- if .Permalink }}<a class="time" href="{{ .Permalink }}">{{ .Time }}</a>{{ else }}<span class="time">{{ .Time }}</span>{{ end }}</div>
+<div class="body">{{ .Body }}</div>
+{{- range .Files }}
+<div class="file">{{ if .Image }}<a href="{{ .URL }}"><img src="{{ if .DataURI }}{{ .DataURI }}{{ else }}{{ .URL }}{{ end }}" alt="{{ .Name }}"></a>{{ else }}<a href="{{ .URL }}">{{ .Name }}</a>{{ end }}</div>
+{{- end }}
+{{- if .Reactions }}
+<div>{{ range .Reactions }}<span class="reaction" title="{{ join .Users ", " }}">:{{ .Name }}: {{ .Count }}</span>{{ end }}</div>
+{{- end }}
+{{- if .Replies }}
+<details>
+<summary>{{ len .Replies }} replies</summary>
+<div class="replies">
+{{- range .Replies }}
+{{ template "message" . }}
+{{- end }}
+</div>
+</details>
+{{- end }}
+</div>
+</div>
+{{- end }}
+`))
@@ -0,0 +1,52 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHTMLFormatterSanitizesFileURLs(t *testing.T) {
+	dir := t.TempDir()
+	png := filepath.Join(dir, "a.png")
+	// NOTE: PNGのシグネチャだけでimage/pngと判定される
+	if err := os.WriteFile(png, []byte("\x89PNG\r\n\x1a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outputs := Outputs{{
+		ID:       "1700000001.000000",
+		Username: "alice",
+		LocalFiles: []*LocalFile{
+			{id: "F1", name: "a.png", path: png},
+			{id: "F2", name: "b.png", path: png},
+		},
+	}}
+	urls := map[string]string{"F1": "javascript:alert(1)", "F2": "https://example.com/b.png"}
+	formatFileName := func(f *LocalFile) string { return urls[f.id] }
+
+	f := NewHTMLFormatter(testLogger(), false)
+	html := string(f.Format(&Target{ChannelName: "general"}, outputs, formatFileName))
+	if strings.Contains(html, "javascript:") {
+		t.Errorf("a javascript: URL reaches the page:\n%s", html)
+	}
+	if !strings.Contains(html, `src="https://example.com/b.png"`) {
+		t.Errorf("the image is not linked:\n%s", html)
+	}
+	if !strings.Contains(html, `<html lang="ja">`) {
+		t.Errorf("lang is not the default ja:\n%s", html)
+	}
+
+	f = NewHTMLFormatter(testLogger(), true)
+	f.Lang = "en"
+	html = string(f.Format(&Target{ChannelName: "general"}, outputs, formatFileName))
+	if !strings.Contains(html, `src="data:image/png;base64,`) {
+		t.Errorf("the image is not embedded:\n%s", html)
+	}
+	if strings.Contains(html, `href="javascript:`) {
+		t.Errorf("a javascript: URL reaches the link:\n%s", html)
+	}
+	if !strings.Contains(html, `<html lang="en">`) {
+		t.Errorf("lang is not en:\n%s", html)
+	}
+}
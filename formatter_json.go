@@ -0,0 +1,163 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// JSONFormatter writes all messages as a JSON array.
+type JSONFormatter struct {
+	// TimeFormat.Location is the timezone of timestamps. Layout is ignored since timestamps are RFC 3339.
+	TimeFormat
+	// FlattenReplies puts replies next to the thread root instead of nesting them in "replies".
+	FlattenReplies bool
+}
+
+var _ FormatterInterface = (*JSONFormatter)(nil)
+var _ FormatterWithErrorInterface = (*JSONFormatter)(nil)
+
+func NewJSONFormatter(flattenReplies bool) *JSONFormatter {
+	return &JSONFormatter{
+		FlattenReplies: flattenReplies,
+	}
+}
+
+func (f *JSONFormatter) Format(target *Target, outputs Outputs, writeFileName func(*LocalFile) string) []byte {
+	b, err := f.FormatE(target, outputs, writeFileName)
+	if err != nil {
+		slog.Error("an error occurred", "function", "JSONFormatter.Format", "channel", target.ChannelID, "error", err.Error())
+		return nil
+	}
+	return b
+}
+
+func (f *JSONFormatter) FormatE(target *Target, outputs Outputs, writeFileName func(*LocalFile) string) ([]byte, error) {
+	messages := toJSONMessages(target, outputs, writeFileName, f.FlattenReplies, f.TimeFormat)
+	return json.MarshalIndent(messages, "", "  ")
+}
+
+// JSONLFormatter writes one message per line (JSON Lines).
+type JSONLFormatter struct {
+	// TimeFormat.Location is the timezone of timestamps. Layout is ignored since timestamps are RFC 3339.
+	TimeFormat
+	// FlattenReplies writes replies as their own lines instead of nesting them in "replies".
+	FlattenReplies bool
+}
+
+var _ FormatterInterface = (*JSONLFormatter)(nil)
+var _ FormatterWithErrorInterface = (*JSONLFormatter)(nil)
+
+func NewJSONLFormatter(flattenReplies bool) *JSONLFormatter {
+	return &JSONLFormatter{
+		FlattenReplies: flattenReplies,
+	}
+}
+
+func (f *JSONLFormatter) Format(target *Target, outputs Outputs, writeFileName func(*LocalFile) string) []byte {
+	b, err := f.FormatE(target, outputs, writeFileName)
+	if err != nil {
+		slog.Error("an error occurred", "function", "JSONLFormatter.Format", "channel", target.ChannelID, "error", err.Error())
+		return nil
+	}
+	return b
+}
+
+func (f *JSONLFormatter) FormatE(target *Target, outputs Outputs, writeFileName func(*LocalFile) string) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	for _, message := range toJSONMessages(target, outputs, writeFileName, f.FlattenReplies, f.TimeFormat) {
+		if err := enc.Encode(message); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+type jsonMessage struct {
+	// ID is unique across channels.
+	ID          string    `json:"id"`
+	ChannelID   string    `json:"channel_id"`
+	ChannelName string    `json:"channel_name,omitempty"`
+	UserID      string    `json:"user_id,omitempty"`
+	Username    string    `json:"username,omitempty"`
+	UserAvatar  string    `json:"user_avatar,omitempty"`
+	Ts          string    `json:"ts"`
+	ThreadTs    string    `json:"thread_ts,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+	Text        string    `json:"text"`
+	Permalink   string    `json:"permalink,omitempty"`
+
+	SubType     string        `json:"subtype,omitempty"`
+	Edited      *Edit         `json:"edited,omitempty"`
+	Deleted     bool          `json:"deleted,omitempty"`
+	Blocks      []*Block      `json:"blocks,omitempty"`
+	Attachments []*Attachment `json:"attachments,omitempty"`
+	Files       []*jsonFile   `json:"files,omitempty"`
+	Reactions   []*Reaction   `json:"reactions,omitempty"`
+
+	Replies []*jsonMessage `json:"replies,omitempty"`
+}
+
+type jsonFile struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// URL is the file name formatted by the FileExporter.
+	URL string `json:"url"`
+}
+
+func toJSONMessages(target *Target, outputs Outputs, writeFileName func(*LocalFile) string, flatten bool, tf TimeFormat) []*jsonMessage {
+	sort.Slice(outputs, func(i, j int) bool { return outputs[i].Timestamp.Before(outputs[j].Timestamp) })
+
+	messages := []*jsonMessage{}
+	for _, output := range outputs {
+		message := toJSONMessage(target, output, writeFileName, tf)
+		messages = append(messages, message)
+
+		sort.Slice(output.Replies, func(i, j int) bool { return output.Replies[i].Timestamp.Before(output.Replies[j].Timestamp) })
+		for _, reply := range output.Replies {
+			replyMessage := toJSONMessage(target, reply, writeFileName, tf)
+			if flatten {
+				messages = append(messages, replyMessage)
+			} else {
+				message.Replies = append(message.Replies, replyMessage)
+			}
+		}
+	}
+	return messages
+}
+
+func toJSONMessage(target *Target, output *Output, writeFileName func(*LocalFile) string, tf TimeFormat) *jsonMessage {
+	files := []*jsonFile{}
+	for _, f := range output.LocalFiles {
+		files = append(files, &jsonFile{
+			ID:   f.id,
+			Name: f.name,
+			URL:  writeFileName(f),
+		})
+	}
+
+	return &jsonMessage{
+		ID:          target.ChannelID + "-" + output.ID,
+		ChannelID:   target.ChannelID,
+		ChannelName: target.ChannelName,
+		UserID:      output.UserID,
+		Username:    output.Username,
+		UserAvatar:  output.UserAvatar,
+		Ts:          output.ID,
+		ThreadTs:    output.ThreadTimestamp,
+		Timestamp:   tf.inLocation(output.Timestamp),
+		Text:        output.Text,
+		Permalink:   output.Permalink,
+
+		SubType:     output.SubType,
+		Edited:      output.Edited,
+		Deleted:     output.Deleted,
+		Blocks:      output.Blocks,
+		Attachments: output.Attachments,
+		Files:       files,
+		Reactions:   output.Reactions,
+	}
+}
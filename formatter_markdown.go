@@ -0,0 +1,242 @@
+package archive
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MarkdownFormatter writes CommonMark with a heading per day. Replies are rendered as blockquotes.
+type MarkdownFormatter struct {
+	// TimeFormat.Layout empty means "15:04:05" because of the day headings.
+	TimeFormat
+}
+
+var _ FormatterInterface = (*MarkdownFormatter)(nil)
+
+func NewMarkdownFormatter() *MarkdownFormatter {
+	return &MarkdownFormatter{}
+}
+
+func (f *MarkdownFormatter) Format(target *Target, outputs Outputs, writeFileName func(*LocalFile) string) []byte {
+	sort.Slice(outputs, func(i, j int) bool { return outputs[i].Timestamp.Before(outputs[j].Timestamp) })
+
+	sections := []string{fmt.Sprintf("# #%s", target.ChannelName)}
+	var day string
+	for _, output := range outputs {
+		if d := f.formatDay(output.Timestamp); d != day {
+			day = d
+			sections = append(sections, "## "+day)
+		}
+		sections = append(sections, f.message(output, writeFileName))
+
+		sort.Slice(output.Replies, func(i, j int) bool { return output.Replies[i].Timestamp.Before(output.Replies[j].Timestamp) })
+		for _, reply := range output.Replies {
+			sections = append(sections, markdownQuote(f.message(reply, writeFileName)))
+		}
+	}
+	return []byte(strings.Join(sections, "\n\n") + "\n")
+}
+
+func (f *MarkdownFormatter) message(output *Output, writeFileName func(*LocalFile) string) string {
+	tf := f.TimeFormat
+	if tf.Layout == "" {
+		tf.Layout = "15:04:05"
+	}
+	header := fmt.Sprintf("**%s** %s", markdownEscape(output.Username), tf.formatTime(output.Timestamp))
+	if output.Permalink != "" {
+		header = fmt.Sprintf("**%s** [%s](%s)", markdownEscape(output.Username), tf.formatTime(output.Timestamp), output.Permalink)
+	}
+
+	lines := []string{header, "", mrkdwnToMarkdown(formatMessageText(output, messageBody(output), f.TimeFormat))}
+	for _, lf := range output.LocalFiles {
+		link := fmt.Sprintf("[%s](<%s>)", markdownEscape(lf.name), writeFileName(lf))
+		if ctype, err := lf.detectContentType(); err == nil && strings.HasPrefix(ctype, "image/") {
+			link = "!" + link
+		}
+		lines = append(lines, "", link)
+	}
+	if len(output.Reactions) != 0 {
+		reactions := []string{}
+		for _, r := range output.Reactions {
+			reactions = append(reactions, fmt.Sprintf(":%s: %d", r.Name, r.Count))
+		}
+		lines = append(lines, "", strings.Join(reactions, " "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func markdownQuote(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if line == "" {
+			lines[i] = ">"
+		} else {
+			lines[i] = "> " + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func markdownEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "<", "&lt;").Replace(s)
+}
+
+// mrkdwnToMarkdown converts Slack's *bold*, _italic_, ~strike~ and ```code``` into CommonMark.
+// Text in code is left as it is.
+func mrkdwnToMarkdown(text string) string {
+	var b strings.Builder
+	for i, segment := range strings.Split(text, "```") {
+		// NOTE: ```が閉じられていない場合はSlackでもコードブロックにならない
+		if i%2 == 1 && i != strings.Count(text, "```") {
+			b.WriteString("\n```\n" + strings.Trim(segment, "\n") + "\n```\n")
+			continue
+		}
+		if i%2 == 1 {
+			b.WriteString("```")
+		}
+		codeSpans := strings.Split(segment, "`")
+		for j, span := range codeSpans {
+			if j != 0 {
+				b.WriteString("`")
+			}
+			if j%2 == 1 && j != len(codeSpans)-1 {
+				b.WriteString(span)
+				continue
+			}
+			span = strings.NewReplacer("<", "&lt;").Replace(span)
+			span = convertEmphasis(span, '*', "**")
+			span = convertEmphasis(span, '_', "*")
+			span = convertEmphasis(span, '~', "~~")
+			b.WriteString(span)
+		}
+	}
+	return markdownHardBreaks(markdownEscapeBlocks(strings.Trim(b.String(), "\n")))
+}
+
+// markdownEscapeBlocks escapes block syntax at the start of lines outside code blocks,
+// so that message text such as "# not heading" or "1. item" is not rendered as a heading or a list.
+func markdownEscapeBlocks(s string) string {
+	lines := strings.Split(s, "\n")
+	var inFence bool
+	for i, line := range lines {
+		if line == "```" {
+			inFence = !inFence
+			continue
+		}
+		if !inFence {
+			lines[i] = markdownEscapeBlock(line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func markdownEscapeBlock(line string) string {
+	body := strings.TrimLeft(line, " \t")
+	indent := line[:len(line)-len(body)]
+	if body == "" {
+		return line
+	}
+
+	// NOTE: "---" や "===" は直前の行を見出しにする
+	if isMarkdownRule(body) {
+		return indent + `\` + body
+	}
+	switch body[0] {
+	case '#', '>':
+		return indent + `\` + body
+	case '-', '+', '*':
+		if len(body) == 1 || body[1] == ' ' || body[1] == '\t' {
+			return indent + `\` + body
+		}
+	case '~':
+		if strings.HasPrefix(body, "~~~") {
+			return indent + `\` + body
+		}
+	}
+
+	// "1. item" or "1) item"
+	digits := len(body) - len(strings.TrimLeft(body, "0123456789"))
+	if digits > 0 && digits <= 9 && digits < len(body) && (body[digits] == '.' || body[digits] == ')') &&
+		(digits+1 == len(body) || body[digits+1] == ' ' || body[digits+1] == '\t') {
+		return indent + body[:digits] + `\` + body[digits:]
+	}
+	return line
+}
+
+// isMarkdownRule reports whether line is a thematic break or a setext heading underline such as "---", "* * *" or "===".
+func isMarkdownRule(line string) bool {
+	var c rune
+	var count int
+	for _, r := range line {
+		switch {
+		case r == ' ' || r == '\t':
+			continue
+		case c == 0 && strings.ContainsRune("-*_=", r):
+			c = r
+		case r != c:
+			return false
+		}
+		count++
+	}
+	return count >= 3 || (c == '=' && count > 0)
+}
+
+// markdownHardBreaks keeps line breaks of Slack messages, which CommonMark would join into one paragraph.
+func markdownHardBreaks(s string) string {
+	lines := strings.Split(s, "\n")
+	var inFence bool
+	for i, line := range lines {
+		if line == "```" {
+			inFence = !inFence
+			continue
+		}
+		if inFence || line == "" || i == len(lines)-1 || lines[i+1] == "" || lines[i+1] == "```" {
+			continue
+		}
+		lines[i] = line + "\\"
+	}
+	return strings.Join(lines, "\n")
+}
+
+// convertEmphasis replaces delim pairs at word boundaries in a line with repl.
+// Like Slack, CJK characters are boundaries as well, since the text has no spaces between words.
+func convertEmphasis(s string, delim byte, repl string) string {
+	isBoundary := func(i int) bool {
+		if i < 0 || i >= len(s) || strings.IndexByte(" \t\n()[].,:;!?'\"", s[i]) >= 0 {
+			return true
+		}
+		if s[i] < utf8.RuneSelf {
+			return false
+		}
+		r, _ := utf8.DecodeRuneInString(s[i:])
+		if !utf8.RuneStart(s[i]) {
+			// NOTE: 区切りの前の文字はそのバイト列の最後を指しているので、文字の先頭から読む
+			r, _ = utf8.DecodeLastRuneInString(s[:i+1])
+		}
+		return isCJK(r) || unicode.IsPunct(r) || unicode.IsSpace(r)
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != delim || !isBoundary(i-1) {
+			b.WriteByte(s[i])
+			continue
+		}
+		end := strings.IndexByte(s[i+1:], delim)
+		if end <= 0 || strings.Contains(s[i+1:i+1+end], "\n") || !isBoundary(i+1+end+1) ||
+			s[i+1] == ' ' || s[i+end] == ' ' {
+			b.WriteByte(s[i])
+			continue
+		}
+		b.WriteString(repl + s[i+1:i+1+end] + repl)
+		i += end + 1
+	}
+	return b.String()
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
@@ -0,0 +1,66 @@
+package archive
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMrkdwnToMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "emphasis", text: "*bold* _italic_ ~strike~", want: "**bold** *italic* ~~strike~~"},
+		{name: "emphasis in CJK", text: "これは*太字*です", want: "これは**太字**です"},
+		{name: "italic in CJK", text: "これは_斜体_と~取り消し~です", want: "これは*斜体*と~~取り消し~~です"},
+		{name: "not emphasis in a word", text: "a*b*c snake_case_name", want: "a*b*c snake_case_name"},
+		{name: "heading", text: "# not heading", want: `\# not heading`},
+		{name: "ordered list", text: "1. first\n2) second", want: "1\\. first\\\n2\\) second"},
+		{name: "bullet list", text: "- a\n+ b\n* c", want: "\\- a\\\n\\+ b\\\n\\* c"},
+		{name: "quote", text: "> quoted", want: `\> quoted`},
+		{name: "thematic break", text: "---", want: `\---`},
+		{name: "setext heading", text: "title\n===", want: "title\\\n\\==="},
+		{name: "indented", text: "  # x", want: `  \# x`},
+		{name: "hashtag and numbers", text: "#hashtag 2024.07 -1", want: "\\#hashtag 2024.07 -1"},
+		{name: "bold at the start", text: "*bold* line", want: "**bold** line"},
+		{name: "code span", text: "`*x* <y>` <z>", want: "`*x* <y>` &lt;z>"},
+		{name: "code block", text: "```# code\n1. x```", want: "```\n# code\n1. x\n```"},
+		{name: "unclosed code block", text: "```# x", want: "```# x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mrkdwnToMarkdown(tt.text); got != tt.want {
+				t.Errorf("mrkdwnToMarkdown(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenizeMrkdwn(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []mrkdwnToken
+	}{
+		{name: "text", text: "a &lt;b&gt; &amp; c", want: []mrkdwnToken{{Type: mrkdwnText, Text: "a <b> & c"}}},
+		{name: "user", text: "hi <@U1>!", want: []mrkdwnToken{
+			{Type: mrkdwnText, Text: "hi "},
+			{Type: mrkdwnUser, ID: "U1"},
+			{Type: mrkdwnText, Text: "!"},
+		}},
+		{name: "channel with label", text: "<#C1|general>", want: []mrkdwnToken{{Type: mrkdwnChannel, ID: "C1", Label: "general"}}},
+		{name: "usergroup", text: "<!subteam^S1|@team>", want: []mrkdwnToken{{Type: mrkdwnUsergroup, ID: "S1", Label: "@team"}}},
+		{name: "special", text: "<!here>", want: []mrkdwnToken{{Type: mrkdwnSpecial, ID: "here"}}},
+		{name: "date", text: "<!date^1700000000^{date}|Nov 14>", want: []mrkdwnToken{{Type: mrkdwnDate, ID: "1700000000^{date}", Label: "Nov 14"}}},
+		{name: "link", text: "<https://example.com/?a=1&amp;b=2|example>", want: []mrkdwnToken{{Type: mrkdwnLink, ID: "https://example.com/?a=1&b=2", Label: "example"}}},
+		{name: "unclosed", text: "a <b", want: []mrkdwnToken{{Type: mrkdwnText, Text: "a <b"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tokenizeMrkdwn(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tokenizeMrkdwn(%q) = %+v, want %+v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
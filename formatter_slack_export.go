@@ -0,0 +1,136 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path"
+	"sort"
+
+	"github.com/slack-go/slack"
+)
+
+// SlackExportFormatter writes a ZIP in the layout of Slack's workspace export:
+// channels.json, users.json and <channel>/<YYYY-MM-DD>.json with the original message objects.
+// url_private of archived files points to FormatFileName so export viewers can open them.
+// Outputs without the original Slack message, such as from custom collectors, are skipped.
+// users.json has the authors and the users mentioned, reacted or edited in the messages.
+type SlackExportFormatter struct {
+	// TimeFormat.Location decides the date of the day files. Slack uses the workspace timezone.
+	TimeFormat
+}
+
+var _ FormatterInterface = (*SlackExportFormatter)(nil)
+var _ FormatterWithErrorInterface = (*SlackExportFormatter)(nil)
+
+func NewSlackExportFormatter() *SlackExportFormatter {
+	return &SlackExportFormatter{}
+}
+
+// Format returns nil if the ZIP cannot be written. Run uses FormatE to get the error.
+func (f *SlackExportFormatter) Format(target *Target, outputs Outputs, writeFileName func(*LocalFile) string) []byte {
+	b, err := f.FormatE(target, outputs, writeFileName)
+	if err != nil {
+		slog.Error("an error occurred", "function", "SlackExportFormatter.Format", "channel", target.ChannelID, "error", err.Error())
+		return nil
+	}
+	return b
+}
+
+func (f *SlackExportFormatter) FormatE(target *Target, outputs Outputs, writeFileName func(*LocalFile) string) ([]byte, error) {
+	days := map[string][]slack.Message{}
+	users := map[string]*slack.User{}
+	add := func(o *Output) {
+		if o.raw == nil {
+			return
+		}
+		day := f.inLocation(o.Timestamp).Format("2006-01-02")
+		days[day] = append(days[day], exportMessage(o, writeFileName))
+		for _, u := range o.users {
+			users[u.ID] = u
+		}
+	}
+	for _, output := range outputs {
+		add(output)
+		for _, reply := range output.Replies {
+			add(reply)
+		}
+	}
+
+	channel := target.channel
+	if channel == nil {
+		channel = &slack.Channel{}
+		channel.ID = target.ChannelID
+		channel.Name = target.ChannelName
+	}
+	dir := firstString([]string{channel.Name, channel.ID})
+
+	userList := []*slack.User{}
+	for _, u := range users {
+		userList = append(userList, u)
+	}
+	sort.Slice(userList, func(i, j int) bool { return userList[i].ID < userList[j].ID })
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	writeJSON := func(name string, v any) error {
+		w, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "    ")
+		if err := enc.Encode(v); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+		return nil
+	}
+
+	if err := writeJSON("channels.json", []*slack.Channel{channel}); err != nil {
+		return nil, err
+	}
+	if err := writeJSON("users.json", userList); err != nil {
+		return nil, err
+	}
+	dayNames := []string{}
+	for day := range days {
+		dayNames = append(dayNames, day)
+	}
+	sort.Strings(dayNames)
+	for _, day := range dayNames {
+		messages := days[day]
+		sort.Slice(messages, func(i, j int) bool { return messages[i].Timestamp < messages[j].Timestamp })
+		if err := writeJSON(path.Join(dir, day+".json"), messages); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// exportMessage returns a copy of the original message whose archived files link to writeFileName.
+func exportMessage(o *Output, writeFileName func(*LocalFile) string) slack.Message {
+	msg := *o.raw
+	if len(msg.Files) == 0 {
+		return msg
+	}
+
+	localFiles := map[string]*LocalFile{}
+	for _, lf := range o.LocalFiles {
+		localFiles[lf.id] = lf
+	}
+	files := append([]slack.File{}, msg.Files...)
+	for i, file := range files {
+		if lf, ok := localFiles[file.ID]; ok {
+			files[i].URLPrivate = writeFileName(lf)
+			files[i].URLPrivateDownload = writeFileName(lf)
+		}
+	}
+	msg.Files = files
+	return msg
+}
@@ -0,0 +1,81 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+func TestSlackExportFormatterUsers(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"channels.json": `[{"id":"C1","name":"general"}]`,
+		"users.json":    `[{"id":"U1","name":"alice"},{"id":"U2","name":"bob"},{"id":"U3","name":"carol"},{"id":"U4","name":"dave"}]`,
+		"general/2024-07-01.json": `[{"type":"message","user":"U1","text":"hello <@U2>","ts":"1719800000.000100",
+			"reactions":[{"name":"+1","count":1,"users":["U3"]}]}]`,
+	}
+	for name, body := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conf := &Config{
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Since:  time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC),
+		Until:  time.Date(2024, 7, 2, 0, 0, 0, 0, time.UTC),
+	}
+	c, err := NewSlackExportCollector(conf, &SlackExportCollectorConfig{Path: dir})
+	if err != nil {
+		t.Fatalf("NewSlackExportCollector: %v", err)
+	}
+	defer c.Clean()
+	targets, err := c.Targets(context.Background())
+	if err != nil {
+		t.Fatalf("Targets: %v", err)
+	}
+	outputs, err := c.Execute(context.Background(), targets[0])
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	b, err := NewSlackExportFormatter().FormatE(targets[0], outputs, func(*LocalFile) string { return "" })
+	if err != nil {
+		t.Fatalf("FormatE: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := zr.Open("users.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	users := []*slack.User{}
+	if err := json.NewDecoder(f).Decode(&users); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []string{}
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+	// NOTE: メンションとリアクションだけのユーザーも含み、無関係なU4は含まない
+	if got, want := ids, []string{"U1", "U2", "U3"}; !slices.Equal(got, want) {
+		t.Errorf("users = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,128 @@
+package archive
+
+import (
+	"bytes"
+	"fmt"
+	htmlTemplate "html/template"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	textTemplate "text/template"
+	"time"
+)
+
+// TemplateFormatter renders outputs with a user-supplied Go template.
+// Files ending with .html or .htm are parsed as html/template, others as text/template.
+type TemplateFormatter struct {
+	name   string
+	text   string
+	isHTML bool
+	// TimeFormat.Location is the timezone of the date function.
+	TimeFormat
+}
+
+var _ FormatterInterface = (*TemplateFormatter)(nil)
+var _ FormatterWithErrorInterface = (*TemplateFormatter)(nil)
+
+// TemplateData is the data passed to the template.
+type TemplateData struct {
+	ChannelID   string
+	ChannelName string
+	Since       time.Time
+	Until       time.Time
+	Outputs     Outputs
+}
+
+func NewTemplateFormatter(templatePath string) (*TemplateFormatter, error) {
+	if templatePath == "" {
+		return nil, fmt.Errorf("templatePath is required.")
+	}
+	b, err := os.ReadFile(templatePath)
+	if err != nil {
+		return nil, err
+	}
+
+	ext := strings.ToLower(filepath.Ext(templatePath))
+	f := &TemplateFormatter{
+		name:   filepath.Base(templatePath),
+		text:   string(b),
+		isHTML: ext == ".html" || ext == ".htm",
+	}
+	// NOTE: テンプレートの構文エラーはFormatではなくここで返す
+	if _, err := f.parse(func(*LocalFile) string { return "" }); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Format returns nil if the template fails. Run uses FormatE to get the error.
+func (f *TemplateFormatter) Format(target *Target, outputs Outputs, writeFileName func(*LocalFile) string) []byte {
+	b, err := f.FormatE(target, outputs, writeFileName)
+	if err != nil {
+		slog.Error("an error occurred", "function", "TemplateFormatter.Format", "channel", target.ChannelID, "error", err.Error())
+		return nil
+	}
+	return b
+}
+
+// FormatE returns errors of the template such as a field of nil, which parsing cannot find.
+func (f *TemplateFormatter) FormatE(target *Target, outputs Outputs, writeFileName func(*LocalFile) string) ([]byte, error) {
+	tmpl, err := f.parse(writeFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(outputs, func(i, j int) bool { return outputs[i].Timestamp.Before(outputs[j].Timestamp) })
+	for _, output := range outputs {
+		sort.Slice(output.Replies, func(i, j int) bool { return output.Replies[i].Timestamp.Before(output.Replies[j].Timestamp) })
+	}
+
+	data := &TemplateData{
+		ChannelID:   target.ChannelID,
+		ChannelName: target.ChannelName,
+		Since:       target.Since,
+		Until:       target.Until,
+		Outputs:     outputs,
+	}
+	// NOTE: 途中まで書かれた出力を使わないよう、バッファに実行してから返す
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, data); err != nil {
+		return nil, fmt.Errorf("failed to execute template %s: %w", f.name, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// templateExecutor is either *text/template.Template or *html/template.Template.
+type templateExecutor interface {
+	Execute(io.Writer, any) error
+}
+
+func (f *TemplateFormatter) parse(writeFileName func(*LocalFile) string) (templateExecutor, error) {
+	funcs := map[string]any{
+		"date": func(layout string, t time.Time) string {
+			return f.inLocation(t).Format(layout)
+		},
+		"indent": func(prefix, s string) string {
+			return prefix + strings.ReplaceAll(s, "\n", "\n"+prefix)
+		},
+		"fileName":     writeFileName,
+		"body":         func(o *Output) string { return formatMessageText(o, messageBody(o), f.TimeFormat) },
+		"mrkdwnToText": mrkdwnToText,
+	}
+	if f.isHTML {
+		return htmlTemplate.New(f.name).Funcs(funcs).Parse(f.text)
+	}
+	return textTemplate.New(f.name).Funcs(funcs).Parse(f.text)
+}
+
+// mrkdwnToText removes Slack's *bold*, _italic_, ~strike~ and code markers.
+func mrkdwnToText(text string) string {
+	text = strings.ReplaceAll(text, "```", "")
+	text = convertEmphasis(text, '*', "")
+	text = convertEmphasis(text, '_', "")
+	text = convertEmphasis(text, '~', "")
+	return convertEmphasis(text, '`', "")
+}
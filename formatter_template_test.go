@@ -0,0 +1,30 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTemplateFormatterExecuteError(t *testing.T) {
+	templatePath := filepath.Join(t.TempDir(), "archive.tmpl")
+	// NOTE: 構文は正しいが、Editedがnilのメッセージで実行時に失敗する
+	if err := os.WriteFile(templatePath, []byte(`{{ range .Outputs }}{{ .Edited.Username }}{{ end }}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewTemplateFormatter(templatePath)
+	if err != nil {
+		t.Fatalf("NewTemplateFormatter: %v", err)
+	}
+
+	target := &Target{ChannelID: "C1"}
+	outputs := Outputs{{ID: "1700000000.000000", Timestamp: time.Unix(1700000000, 0), Text: "hi"}}
+	fileName := func(*LocalFile) string { return "" }
+	if _, err := format(f, target, outputs, fileName); err == nil {
+		t.Fatal("format succeeded, want the template error")
+	}
+	if b := f.Format(target, outputs, fileName); b != nil {
+		t.Errorf("Format = %q, want nil", b)
+	}
+}
@@ -5,14 +5,16 @@ import (
 )
 
 /* Example
+collector := NewCollector()
 formatter := NewFormatter()
 textExporter := NewTextExporter()
 fileExporter := NewFileExporter()
 
 config := &Config{
-	formatter:    formatter,
-	textExporter: textExporter,
-	fileExporter: fileExporter,
+	Collector:    collector,
+	Formatter:    formatter,
+	TextExporter: textExporter,
+	FileExporter: fileExporter,
 	...
 }
 
@@ -20,17 +22,40 @@ Run(context.Background(), config)
 */
 
 type CollectorInterface interface {
-	Execute(context.Context) (Outputs, error)
+	Targets(context.Context) ([]*Target, error)
+	Execute(context.Context, *Target) (Outputs, error)
+	// Clean removes temporary files and closes resources. Run calls it when it finishes.
+	Clean()
 }
 
 type FormatterInterface interface {
-	Format(Outputs, func(*LocalFile) string) []byte
+	Format(*Target, Outputs, func(*LocalFile) string) []byte
+}
+
+// FormatterWithErrorInterface is an optional capability of formatters which can fail on the outputs,
+// such as a user template. Run calls FormatE instead of Format and fails only the channel.
+type FormatterWithErrorInterface interface {
+	FormatE(*Target, Outputs, func(*LocalFile) string) ([]byte, error)
 }
 
 type TextExporterInterface interface {
-	Write(context.Context, []byte) error
+	Write(context.Context, *Target, []byte) error
 }
 type FileExporterInterface interface {
-	WriteFiles(context.Context, []*LocalFile) error
-	FormatFileName(*LocalFile) string
+	WriteFiles(context.Context, *Target, []*LocalFile) error
+	FormatFileName(*Target, *LocalFile) string
+}
+
+// FileExistsInterface is an optional capability of file exporters.
+// SlackCollector does not download files which already exist, and Run does not write them again.
+type FileExistsInterface interface {
+	Exists(context.Context, *Target, *LocalFile) (bool, error)
+}
+
+// CheckpointStoreInterface saves a checkpoint per channel,
+// so that concurrent runs for different channels do not overwrite each other.
+type CheckpointStoreInterface interface {
+	// Load returns nil if nothing has been saved for the channel yet.
+	Load(ctx context.Context, channelID string) (*ChannelCheckpoint, error)
+	Save(ctx context.Context, channelID string, ccp *ChannelCheckpoint) error
 }
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"math/rand"
 	"mime/multipart"
+	"net/http"
 	"net/textproto"
 	"strings"
 )
@@ -41,9 +42,15 @@ func toMIMEBody(data []byte, boundary string) ([]byte, error) {
 		return nil, err
 	}
 
+	// NOTE: HTMLFormatterの出力はHTMLメールとして送る
+	contentType := "text/plain; charset=utf-8"
+	if strings.HasPrefix(http.DetectContentType(data), "text/html") {
+		contentType = "text/html; charset=utf-8"
+	}
+
 	// multipart html part
 	part, err := bodyWriter.CreatePart(textproto.MIMEHeader{
-		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Type":              {contentType},
 		"Content-Transfer-Encoding": {"base64"},
 	})
 	if err != nil {
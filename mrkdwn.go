@@ -0,0 +1,145 @@
+package archive
+
+import (
+	"strings"
+)
+
+// Slack mrkdwn entities
+// ref: https://api.slack.com/reference/surfaces/formatting#advanced
+type mrkdwnTokenType int
+
+const (
+	mrkdwnText mrkdwnTokenType = iota
+	mrkdwnUser
+	mrkdwnChannel
+	mrkdwnUsergroup
+	mrkdwnSpecial
+	mrkdwnDate
+	mrkdwnLink
+)
+
+type mrkdwnToken struct {
+	Type mrkdwnTokenType
+	// Text is the unescaped text of mrkdwnText.
+	Text string
+	// ID is the user, channel or usergroup ID, the special mention name or the link URL.
+	ID string
+	// Label is the text after "|", if any.
+	Label string
+}
+
+func tokenizeMrkdwn(text string) []mrkdwnToken {
+	tokens := []mrkdwnToken{}
+	appendText := func(s string) {
+		if s == "" {
+			return
+		}
+		tokens = append(tokens, mrkdwnToken{Type: mrkdwnText, Text: unescapeMrkdwn(s)})
+	}
+
+	for {
+		start := strings.IndexByte(text, '<')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(text[start:], '>')
+		if end < 0 {
+			break
+		}
+		appendText(text[:start])
+		tokens = append(tokens, parseMrkdwnEntity(text[start+1:start+end]))
+		text = text[start+end+1:]
+	}
+	appendText(text)
+
+	return tokens
+}
+
+func parseMrkdwnEntity(s string) mrkdwnToken {
+	body, label, _ := strings.Cut(s, "|")
+	label = unescapeMrkdwn(label)
+
+	switch {
+	case strings.HasPrefix(body, "@"):
+		return mrkdwnToken{Type: mrkdwnUser, ID: body[1:], Label: label}
+	case strings.HasPrefix(body, "#"):
+		return mrkdwnToken{Type: mrkdwnChannel, ID: body[1:], Label: label}
+	case strings.HasPrefix(body, "!subteam^"):
+		return mrkdwnToken{Type: mrkdwnUsergroup, ID: strings.TrimPrefix(body, "!subteam^"), Label: label}
+	case strings.HasPrefix(body, "!date^"):
+		return mrkdwnToken{Type: mrkdwnDate, ID: strings.TrimPrefix(body, "!date^"), Label: label}
+	case strings.HasPrefix(body, "!"):
+		return mrkdwnToken{Type: mrkdwnSpecial, ID: body[1:], Label: label}
+	default:
+		return mrkdwnToken{Type: mrkdwnLink, ID: unescapeMrkdwn(body), Label: label}
+	}
+}
+
+func unescapeMrkdwn(s string) string {
+	return strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&").Replace(s)
+}
+
+// mrkdwnRenderer resolves mrkdwn entities into readable text.
+// IDs not found in the caches are left as they are.
+type mrkdwnRenderer struct {
+	users      *nameCacheClient
+	channels   *nameCacheClient
+	usergroups *nameCacheClient
+	// timeFormat prints dates in Block Kit.
+	timeFormat TimeFormat
+}
+
+func (r *mrkdwnRenderer) render(text string) string {
+	var b strings.Builder
+	for _, token := range tokenizeMrkdwn(text) {
+		b.WriteString(r.renderToken(token))
+	}
+	return b.String()
+}
+
+func (r *mrkdwnRenderer) renderToken(token mrkdwnToken) string {
+	switch token.Type {
+	case mrkdwnText:
+		return token.Text
+	case mrkdwnUser:
+		if token.Label != "" {
+			return "@" + strings.TrimPrefix(token.Label, "@")
+		}
+		return "@" + r.users.get(token.ID)
+	case mrkdwnChannel:
+		if token.Label != "" {
+			return "#" + strings.TrimPrefix(token.Label, "#")
+		}
+		return "#" + r.channels.get(token.ID)
+	case mrkdwnUsergroup:
+		if token.Label != "" {
+			return token.Label
+		}
+		return "@" + r.usergroups.get(token.ID)
+	case mrkdwnSpecial:
+		if token.Label != "" {
+			return token.Label
+		}
+		return "@" + token.ID
+	case mrkdwnDate:
+		return token.Label
+	case mrkdwnLink:
+		url := strings.TrimPrefix(token.ID, "mailto:")
+		if token.Label == "" || token.Label == token.ID || token.Label == url {
+			return url
+		}
+		return token.Label + " (" + url + ")"
+	}
+	return ""
+}
+
+// mrkdwnMentions returns IDs of the entity type mentioned in text without a label.
+func mrkdwnMentions(text string, typ mrkdwnTokenType) []string {
+	ids := []string{}
+	for _, token := range tokenizeMrkdwn(text) {
+		if token.Type == typ && token.Label == "" && token.ID != "" {
+			ids = append(ids, token.ID)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,118 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+type RetryPolicy struct {
+	// MaxAttempts includes the first call. 1 or less disables retry.
+	MaxAttempts int
+	// BaseDelay and MaxDelay bound the exponential backoff for 5xx and network errors.
+	// Rate limited calls wait for Retry-After instead.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   1 * time.Second,
+		MaxDelay:    60 * time.Second,
+	}
+}
+
+// delay returns how long to wait before the next attempt, and false if err is not retryable.
+func (p *RetryPolicy) delay(attempt int, err error) (time.Duration, bool) {
+	var rateLimited *slack.RateLimitedError
+	if errors.As(err, &rateLimited) {
+		return rateLimited.RetryAfter, true
+	}
+
+	var statusCode slack.StatusCodeError
+	var netErr net.Error
+	if !(errors.As(err, &statusCode) && statusCode.Retryable()) && !errors.As(err, &netErr) {
+		return 0, false
+	}
+
+	backoff := p.BaseDelay << (attempt - 1)
+	if backoff <= 0 || backoff > p.MaxDelay {
+		backoff = p.MaxDelay
+	}
+	// equal jitter: [backoff/2, backoff)
+	half := backoff / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1)), true
+}
+
+// rateLimiter makes all workers wait while Slack asks to back off.
+type rateLimiter struct {
+	mu           sync.Mutex
+	blockedUntil time.Time
+}
+
+func (l *rateLimiter) block(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := time.Now().Add(d); until.After(l.blockedUntil) {
+		l.blockedUntil = until
+	}
+}
+
+func (l *rateLimiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	d := time.Until(l.blockedUntil)
+	l.mu.Unlock()
+	if d <= 0 {
+		return nil
+	}
+	return sleep(ctx, d)
+}
+
+// withRetry calls fn until it succeeds, returns a non-retryable error or runs out of attempts.
+func (c *SlackCollector) withRetry(ctx context.Context, name string, fn func() error) error {
+	policy := c.config.Retry
+	for attempt := 1; ; attempt++ {
+		if err := c.rateLimiter.wait(ctx); err != nil {
+			return err
+		}
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil || attempt >= policy.MaxAttempts {
+			return err
+		}
+		wait, ok := policy.delay(attempt, err)
+		if !ok {
+			return err
+		}
+
+		c.logger.Warn("retrying Slack API call", "function", name, "attempt", attempt, "wait", wait.String(), "error", err.Error())
+		var rateLimited *slack.RateLimitedError
+		if errors.As(err, &rateLimited) {
+			// NOTE: rate limitはトークン単位なので、他のworkerも一緒に待たせる
+			c.rateLimiter.block(wait)
+			continue
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
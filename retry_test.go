@@ -0,0 +1,184 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+func getConversationInfo(c *SlackCollector) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		return c.withRetry(ctx, "conversations.info", func() error {
+			_, err := c.slackClient.GetConversationInfoContext(ctx, &slack.GetConversationInfoInput{ChannelID: "C1"})
+			return err
+		})
+	}
+}
+
+const channelInfoOK = `{"ok":true,"channel":{"id":"C1","name":"general"}}`
+
+func TestWithRetryWaitsRetryAfter(t *testing.T) {
+	fake := newFakeSlack(t)
+	fake.handle("conversations.info", respond(
+		statusResponse(http.StatusTooManyRequests, "Retry-After", "1"),
+		jsonResponse(channelInfoOK),
+	))
+	c := newTestCollector(t, fake, &SlackCollectorConfig{})
+
+	if err := getConversationInfo(c)(context.Background()); err != nil {
+		t.Fatalf("withRetry: %v", err)
+	}
+	calls := fake.callTimes("conversations.info")
+	if len(calls) != 2 {
+		t.Fatalf("calls = %d, want 2", len(calls))
+	}
+	if d := calls[1].Sub(calls[0]); d < time.Second {
+		t.Errorf("retried after %s, want at least Retry-After 1s", d)
+	}
+}
+
+func TestWithRetryServerError(t *testing.T) {
+	fake := newFakeSlack(t)
+	fake.handle("conversations.info", respond(
+		statusResponse(http.StatusInternalServerError),
+		statusResponse(http.StatusBadGateway),
+		jsonResponse(channelInfoOK),
+	))
+	c := newTestCollector(t, fake, &SlackCollectorConfig{})
+
+	if err := getConversationInfo(c)(context.Background()); err != nil {
+		t.Fatalf("withRetry: %v", err)
+	}
+	if got := len(fake.callTimes("conversations.info")); got != 3 {
+		t.Errorf("calls = %d, want 3", got)
+	}
+}
+
+func TestWithRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	fake := newFakeSlack(t)
+	fake.handle("conversations.info", respond(
+		statusResponse(http.StatusServiceUnavailable),
+	))
+	c := newTestCollector(t, fake, &SlackCollectorConfig{})
+
+	err := getConversationInfo(c)(context.Background())
+	var statusCode slack.StatusCodeError
+	if !errors.As(err, &statusCode) || statusCode.Code != http.StatusServiceUnavailable {
+		t.Fatalf("err = %v, want 503", err)
+	}
+	if got, want := len(fake.callTimes("conversations.info")), c.config.Retry.MaxAttempts; got != want {
+		t.Errorf("calls = %d, want %d", got, want)
+	}
+}
+
+func TestWithRetryNetworkError(t *testing.T) {
+	fake := newFakeSlack(t)
+	fake.handle("conversations.info", func(w http.ResponseWriter, r *http.Request) {
+		// NOTE: レスポンスを返さずに切断してネットワークエラーにする
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("Hijack: %v", err)
+			return
+		}
+		conn.Close()
+	})
+	c := newTestCollector(t, fake, &SlackCollectorConfig{})
+
+	err := getConversationInfo(c)(context.Background())
+	var netErr net.Error
+	if !errors.As(err, &netErr) {
+		t.Fatalf("err = %v, want a network error", err)
+	}
+	if got, want := len(fake.callTimes("conversations.info")), c.config.Retry.MaxAttempts; got != want {
+		t.Errorf("calls = %d, want %d", got, want)
+	}
+}
+
+func TestWithRetryNonRetryableError(t *testing.T) {
+	fake := newFakeSlack(t)
+	fake.handle("conversations.info", respond(
+		jsonResponse(`{"ok":false,"error":"channel_not_found"}`),
+	))
+	c := newTestCollector(t, fake, &SlackCollectorConfig{})
+
+	err := getConversationInfo(c)(context.Background())
+	if err == nil || err.Error() != "channel_not_found" {
+		t.Fatalf("err = %v, want channel_not_found", err)
+	}
+	if got := len(fake.callTimes("conversations.info")); got != 1 {
+		t.Errorf("calls = %d, want 1", got)
+	}
+}
+
+func TestWithRetrySharesRateLimitAcrossWorkers(t *testing.T) {
+	fake := newFakeSlack(t)
+	fake.handle("conversations.info", respond(
+		statusResponse(http.StatusTooManyRequests, "Retry-After", "1"),
+		jsonResponse(channelInfoOK),
+	))
+	c := newTestCollector(t, fake, &SlackCollectorConfig{Concurrency: 2})
+
+	err := parallel(context.Background(), 2, 2, func(ctx context.Context, i int) error {
+		if i == 1 {
+			// NOTE: 先のworkerがrate limitedになってから呼ぶ
+			time.Sleep(200 * time.Millisecond)
+		}
+		return getConversationInfo(c)(ctx)
+	})
+	if err != nil {
+		t.Fatalf("parallel: %v", err)
+	}
+
+	calls := fake.callTimes("conversations.info")
+	if len(calls) != 3 {
+		t.Fatalf("calls = %d, want 3", len(calls))
+	}
+	for _, call := range calls[1:] {
+		if d := call.Sub(calls[0]); d < time.Second {
+			t.Errorf("a worker called %s after the rate limited call, want at least Retry-After 1s", d)
+		}
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := &RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{attempt: 1, min: 50 * time.Millisecond, max: 100 * time.Millisecond},
+		{attempt: 2, min: 100 * time.Millisecond, max: 200 * time.Millisecond},
+		{attempt: 3, min: 200 * time.Millisecond, max: 400 * time.Millisecond},
+		{attempt: 8, min: 500 * time.Millisecond, max: time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("attempt %d", tt.attempt), func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				d, ok := p.delay(tt.attempt, slack.StatusCodeError{Code: http.StatusInternalServerError})
+				if !ok {
+					t.Fatal("5xx is not retryable")
+				}
+				if d < tt.min || d > tt.max {
+					t.Fatalf("delay = %s, want [%s, %s]", d, tt.min, tt.max)
+				}
+			}
+		})
+	}
+
+	if d, ok := p.delay(1, &slack.RateLimitedError{RetryAfter: 3 * time.Second}); !ok || d != 3*time.Second {
+		t.Errorf("rate limited delay = %s, %v, want Retry-After 3s", d, ok)
+	}
+	if _, ok := p.delay(1, slack.StatusCodeError{Code: http.StatusBadRequest}); ok {
+		t.Error("4xx is retryable")
+	}
+	if _, ok := p.delay(1, errors.New("channel_not_found")); ok {
+		t.Error("Slack API error is retryable")
+	}
+}
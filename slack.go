@@ -2,60 +2,116 @@ package archive
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"path"
+	"slices"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/slack-go/slack"
 )
 
 type SlackCollectorConfig struct {
-	Token   string
-	Channel string
+	Token             string
+	Channels          []string
+	AllJoinedChannels bool
 
 	HistoryLimit int
-	HistryLatest string // archive.Config.Until
-	HistryOldest string // archive.Config.since
 
+	// RetrivalLimit is the max number of pages per conversations.history or conversations.replies.
+	// UnlimitedRetrival (negative) fetches all pages.
 	RetrivalLimit int
+	// FailOnTruncation makes Execute fail when pages remain after RetrivalLimit.
+	FailOnTruncation bool
+
+	// ExcludeSystemMessages drops messages such as channel_join and channel_topic.
+	ExcludeSystemMessages bool
+
+	ThreadLookback time.Duration
+
+	// TimeFormat is how dates in Block Kit are printed. NewSlackCollectorConfig takes it from the formatter.
+	TimeFormat TimeFormat
+
+	Retry *RetryPolicy
+
+	// Concurrency is the number of workers fetching replies, user profiles and files.
+	Concurrency int
 }
 
 func NewSlackCollectorConfig(archiveConf *Config) *SlackCollectorConfig {
 	conf := &SlackCollectorConfig{}
 	conf.HistoryLimit = 200
 	conf.RetrivalLimit = 10
+	if archiveConf.SlackHistoryLimit > 0 {
+		conf.HistoryLimit = archiveConf.SlackHistoryLimit
+	}
+	if archiveConf.SlackRetrivalLimit != 0 {
+		conf.RetrivalLimit = archiveConf.SlackRetrivalLimit
+	}
+	conf.FailOnTruncation = archiveConf.FailOnTruncation
+	conf.ExcludeSystemMessages = archiveConf.ExcludeSystemMessages
 
 	conf.Token = firstString([]string{
 		archiveConf.SlackToken,
 		os.Getenv("SA_SLACK_TOKEN"),
 	})
-	conf.Channel = firstString([]string{
-		archiveConf.SlackChannel,
-		os.Getenv("SA_SLACK_CHANNEL"),
-	})
-
-	if !archiveConf.Since.IsZero() {
-		conf.HistryOldest = strconv.FormatInt(archiveConf.Since.Unix(), 10)
+	conf.Channels = archiveConf.SlackChannels
+	if len(conf.Channels) == 0 {
+		conf.Channels = SplitList(os.Getenv("SA_SLACK_CHANNEL"))
+	}
+	conf.AllJoinedChannels = archiveConf.SlackAllJoinedChannels
+	conf.ThreadLookback = archiveConf.threadLookback()
+	conf.TimeFormat = formatterTimeFormat(archiveConf.Formatter)
+	conf.Retry = DefaultRetryPolicy()
+	if archiveConf.SlackRetry != nil {
+		conf.Retry = archiveConf.SlackRetry
 	}
-	if !archiveConf.Until.IsZero() {
-		conf.HistryLatest = strconv.FormatInt(archiveConf.Until.Unix(), 10)
+	conf.Concurrency = 4
+	if archiveConf.SlackConcurrency > 0 {
+		conf.Concurrency = archiveConf.SlackConcurrency
 	}
+
 	return conf
 }
 
+// UnlimitedRetrival disables the page limit of SlackCollectorConfig.RetrivalLimit.
+const UnlimitedRetrival = -1
+
+// ErrTruncated is returned when FailOnTruncation is set and the archive would be incomplete.
+var ErrTruncated = errors.New("archive truncated by RetrivalLimit")
+
 // Collector
 type SlackCollector struct {
 	config        *SlackCollectorConfig
 	archiveConfig *Config
 	slackClient   *slack.Client
+	rateLimiter   *rateLimiter
+	// teamURL is the workspace URL such as "https://example.slack.com/" for permalinks.
+	teamURL string
+
+	userCache      *nameCacheClient
+	avatarCache    *nameCacheClient
+	channelCache   *nameCacheClient
+	usergroupCache *nameCacheClient
+	// users holds users.info results for SlackExportFormatter
+	users map[string]*slack.User
+	// NOTE: usergroups.listは全件取得なので、コレクタごとに1回だけ呼ぶ
+	usergroupsFetched bool
 
-	userCache     *userCacheClient
 	messages      []slack.Message
 	replyMessages map[string][]slack.Message
+	// threadOldest overrides oldest of conversations.replies for threads posted before the window
+	threadOldest map[string]string
+	// incomplete is set by truncated. Reply workers set it concurrently.
+	incomplete atomic.Bool
+	// threadsIncomplete is set by rescanTruncated for the thread lookback rescan.
+	threadsIncomplete atomic.Bool
 
 	// NOTE: slack.MessageのFilesはなぜかSize=0のファイルが飛んでくる
 	// messages及びreplyMessagesに入れるタイミングで省くのは難しいので、func getAllFiles()で省き、
@@ -63,6 +119,8 @@ type SlackCollector struct {
 	// 入れて返す形になっている
 	tempFileDir   string
 	tempFilePaths map[string]string
+	// existingFiles are files which the file exporter already has, so they are not downloaded
+	existingFiles map[string]bool
 
 	logger *slog.Logger
 }
@@ -81,13 +139,21 @@ func NewSlackCollector(conf *Config, slackConf *SlackCollectorConfig) *SlackColl
 		config:        slackConf,
 		archiveConfig: conf,
 		slackClient:   slack.New(slackConf.Token),
+		rateLimiter:   &rateLimiter{},
+
+		userCache:      newNameCacheClient(),
+		avatarCache:    newNameCacheClient(),
+		users:          map[string]*slack.User{},
+		channelCache:   newNameCacheClient(),
+		usergroupCache: newNameCacheClient(),
 
-		userCache:     newUserCacheClient(),
 		messages:      []slack.Message{},
 		replyMessages: map[string][]slack.Message{},
+		threadOldest:  map[string]string{},
 
 		tempFileDir:   tempFileDirPath,
 		tempFilePaths: map[string]string{},
+		existingFiles: map[string]bool{},
 
 		logger: conf.Logger,
 	}
@@ -99,12 +165,148 @@ func (c *SlackCollector) Clean() {
 	}
 }
 
-func (c *SlackCollector) Execute(ctx context.Context) (Outputs, error) {
-	if err := c.getHistoryMessages(ctx); err != nil {
+func (c *SlackCollector) Targets(ctx context.Context) ([]*Target, error) {
+	channelIDs := []string{}
+	seen := map[string]bool{}
+	for _, id := range c.config.Channels {
+		if !seen[id] {
+			seen[id] = true
+			channelIDs = append(channelIDs, id)
+		}
+	}
+
+	channels := map[string]*slack.Channel{}
+	if c.config.AllJoinedChannels {
+		joined, err := c.getJoinedChannels(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for i, ch := range joined {
+			channels[ch.ID] = &joined[i]
+			if !seen[ch.ID] {
+				seen[ch.ID] = true
+				channelIDs = append(channelIDs, ch.ID)
+			}
+		}
+	}
+	if len(channelIDs) == 0 {
+		return nil, fmt.Errorf("no Slack channel specified")
+	}
+	c.teamURL = c.getTeamURL(ctx)
+
+	targets := []*Target{}
+	for _, id := range channelIDs {
+		ch, ok := channels[id]
+		if !ok {
+			ch = c.getChannel(ctx, id)
+		}
+		c.channelCache.putIfNotExist(id, ch.Name)
+		targets = append(targets, &Target{
+			ChannelID:   id,
+			ChannelName: ch.Name,
+			Since:       c.archiveConfig.Since,
+			Until:       c.archiveConfig.Until,
+			channel:     ch,
+		})
+	}
+
+	c.logger.Info(fmt.Sprintf("SlackCollector: Targets success. channel_count: %d", len(targets)))
+	return targets, nil
+}
+
+// getJoinedChannels returns public channels the bot is a member of.
+func (c *SlackCollector) getJoinedChannels(ctx context.Context) ([]slack.Channel, error) {
+	channels := []slack.Channel{}
+
+	// conversations.list
+	var cur string = ""
+	for {
+		params := &slack.GetConversationsParameters{
+			Cursor:          cur,
+			ExcludeArchived: true,
+			Limit:           c.config.HistoryLimit,
+			Types:           []string{"public_channel"},
+		}
+		var chs []slack.Channel
+		var nextCursor string
+		err := c.withRetry(ctx, "conversations.list", func() (err error) {
+			chs, nextCursor, err = c.slackClient.GetConversationsContext(ctx, params)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, ch := range chs {
+			if ch.IsMember {
+				channels = append(channels, ch)
+			}
+		}
+
+		if nextCursor == "" {
+			break
+		}
+		cur = nextCursor
+	}
+	return channels, nil
+}
+
+func (c *SlackCollector) getTeamURL(ctx context.Context) string {
+	var res *slack.AuthTestResponse
+	err := c.withRetry(ctx, "auth.test", func() (err error) {
+		res, err = c.slackClient.AuthTestContext(ctx)
+		return err
+	})
+	if err != nil {
+		// NOTE: permalinkが出せないだけなので、アーカイブは続ける
+		c.logger.Warn("failed to get team URL", "error", err.Error())
+		return ""
+	}
+	return res.URL
+}
+
+// permalink builds the message URL without calling chat.getPermalink for each message.
+// threadTs is empty for messages in the channel.
+func (c *SlackCollector) permalink(channelID, ts, threadTs string) string {
+	if c.teamURL == "" {
+		return ""
+	}
+	link := fmt.Sprintf("%sarchives/%s/p%s", c.teamURL, channelID, strings.Replace(ts, ".", "", 1))
+	if threadTs != "" {
+		link += fmt.Sprintf("?thread_ts=%s&cid=%s", threadTs, channelID)
+	}
+	return link
+}
+
+func (c *SlackCollector) getChannel(ctx context.Context, channelID string) *slack.Channel {
+	var ch *slack.Channel
+	err := c.withRetry(ctx, "conversations.info", func() (err error) {
+		ch, err = c.slackClient.GetConversationInfoContext(ctx, &slack.GetConversationInfoInput{
+			ChannelID: channelID,
+		})
+		return err
+	})
+	if err != nil {
+		// NOTE: channels:read スコープが無い場合もあるので、チャンネル名はIDで代用する
+		c.logger.Warn("failed to get channel name", "channel", channelID, "error", err.Error())
+		ch = &slack.Channel{}
+		ch.ID = channelID
+		ch.Name = channelID
+	}
+	return ch
+}
+
+func (c *SlackCollector) Execute(ctx context.Context, target *Target) (Outputs, error) {
+	c.reset()
+
+	if err := c.getHistoryMessages(ctx, target); err != nil {
+		return nil, err
+	}
+
+	if err := c.getUpdatedThreads(ctx, target); err != nil {
 		return nil, err
 	}
 
-	if err := c.getHistoryMessagesInThread(ctx); err != nil {
+	if err := c.getHistoryMessagesInThread(ctx, target); err != nil {
 		return nil, err
 	}
 
@@ -112,19 +314,101 @@ func (c *SlackCollector) Execute(ctx context.Context) (Outputs, error) {
 		return nil, err
 	}
 
-	if err := c.getAllFiles(ctx); err != nil {
+	if err := c.getAllFiles(ctx, target); err != nil {
 		return nil, err
 	}
 
-	outputs, err := c.outputs()
+	outputs, err := c.outputs(target)
 	if err != nil {
 		return nil, err
 	}
+	target.truncated = c.incomplete.Load()
+	target.threadsTruncated = c.threadsIncomplete.Load()
 
 	return outputs, nil
 }
 
-func (c *SlackCollector) getHistoryMessages(ctx context.Context) error {
+// reset clears per-channel state. Name caches are kept to reuse across channels.
+func (c *SlackCollector) reset() {
+	for _, p := range c.tempFilePaths {
+		if err := os.Remove(p); err != nil {
+			c.logger.Error("an error occurred", "function", "os.Remove", "error", err.Error())
+		}
+	}
+	c.messages = []slack.Message{}
+	c.replyMessages = map[string][]slack.Message{}
+	c.threadOldest = map[string]string{}
+	c.incomplete.Store(false)
+	c.threadsIncomplete.Store(false)
+	c.tempFilePaths = map[string]string{}
+	c.existingFiles = map[string]bool{}
+}
+
+func (c *SlackCollector) getHistoryMessages(ctx context.Context, target *Target) error {
+	var oldest, latest string
+	if !target.Since.IsZero() {
+		oldest = slackTimestamp(target.Since)
+	}
+	if !target.Until.IsZero() {
+		latest = slackTimestamp(target.Until)
+	}
+
+	messages, hasMore, err := c.fetchHistory(ctx, target.ChannelID, oldest, latest, false)
+	if err != nil {
+		return err
+	}
+	if hasMore {
+		if err := c.truncated("conversations.history", target.ChannelID, "", len(messages)); err != nil {
+			return err
+		}
+	}
+
+	c.messages = messages
+	c.logger.Info(fmt.Sprintf("SlackCollector: getHistoryMessages success. channel: %s, message_count: %d", target.ChannelID, len(messages)))
+	return nil
+}
+
+// getUpdatedThreads picks up threads posted before the checkpoint which got new replies.
+func (c *SlackCollector) getUpdatedThreads(ctx context.Context, target *Target) error {
+	if target.Checkpoint == nil || c.config.ThreadLookback <= 0 {
+		return nil
+	}
+
+	oldest := slackTimestamp(target.Since.Add(-c.config.ThreadLookback))
+	latest := target.Checkpoint.LatestTimestamp
+	// NOTE: 通常の取得ではチェックポイントのメッセージ自体は含まれないので、inclusiveで拾う
+	messages, hasMore, err := c.fetchHistory(ctx, target.ChannelID, oldest, latest, true)
+	if err != nil {
+		return err
+	}
+	// NOTE: 再走査は毎回同じ期間を取り直すので、打ち切られてもチェックポイントの最新tsは進める
+	if hasMore {
+		c.rescanTruncated("conversations.history", target.ChannelID, "", len(messages))
+	}
+
+	var count = 0
+	for _, msg := range messages {
+		if msg.ReplyCount == 0 || msg.SubType == "thread_broadcast" {
+			continue
+		}
+		baseline, ok := target.Checkpoint.Threads[msg.Timestamp]
+		if !ok {
+			baseline = target.Checkpoint.LatestTimestamp
+		}
+		if !timestampAfter(msg.LatestReply, baseline) {
+			continue
+		}
+		c.messages = append(c.messages, msg)
+		c.threadOldest[msg.Timestamp] = baseline
+		count++
+	}
+
+	c.logger.Info(fmt.Sprintf("SlackCollector: getUpdatedThreads success. channel: %s, thread_count: %d", target.ChannelID, count))
+	return nil
+}
+
+// fetchHistory returns messages up to RetrivalLimit pages, and whether more messages remain.
+func (c *SlackCollector) fetchHistory(ctx context.Context, channelID, oldest, latest string, inclusive bool) ([]slack.Message, bool, error) {
 	client := c.slackClient
 	config := c.config
 
@@ -133,45 +417,67 @@ func (c *SlackCollector) getHistoryMessages(ctx context.Context) error {
 	// conversations.history
 	var cur string = ""
 	var count = 0
-	for count < config.RetrivalLimit {
+	var hasMore = true
+	for hasMore && (config.RetrivalLimit < 0 || count < config.RetrivalLimit) {
 		count++
 		params := &slack.GetConversationHistoryParameters{
-			ChannelID:          config.Channel,
+			ChannelID:          channelID,
 			Cursor:             cur,
 			Limit:              config.HistoryLimit,
+			Latest:             latest,
+			Oldest:             oldest,
+			Inclusive:          inclusive,
 			IncludeAllMetadata: false,
 		}
-		if config.HistryLatest != "" {
-			params.Latest = config.HistryLatest
-		}
-		if config.HistryOldest != "" {
-			params.Oldest = config.HistryOldest
-		}
 
-		historyRes, err := client.GetConversationHistoryContext(ctx, params)
-		if err != nil {
+		var historyRes *slack.GetConversationHistoryResponse
+		err := c.withRetry(ctx, "conversations.history", func() (err error) {
+			historyRes, err = client.GetConversationHistoryContext(ctx, params)
 			return err
+		})
+		if err != nil {
+			return nil, false, err
 		}
 		if !historyRes.Ok {
-			return fmt.Errorf("Slack error: %w, %+v", historyRes.Err(), historyRes.ResponseMetadata)
+			return nil, false, fmt.Errorf("Slack error: %w, %+v", historyRes.Err(), historyRes.ResponseMetadata)
 		}
 		messages = append(messages, historyRes.Messages...)
 
-		if !historyRes.HasMore {
-			break
-		}
+		hasMore = historyRes.HasMore
 		cur = historyRes.ResponseMetaData.NextCursor
 	}
+	return messages, hasMore, nil
+}
 
-	c.messages = messages
-	c.logger.Info(fmt.Sprintf("SlackCollector: getHistoryMessages success. message_count: %d", len(messages)))
+// truncated reports that pagination stopped at RetrivalLimit while more messages remain.
+func (c *SlackCollector) truncated(method, channelID, threadTs string, fetched int) error {
+	c.incomplete.Store(true)
+	if c.config.FailOnTruncation {
+		return fmt.Errorf("%w: %s channel: %s, thread_ts: %s, fetched: %d, retrival_limit: %d",
+			ErrTruncated, method, channelID, threadTs, fetched, c.config.RetrivalLimit)
+	}
+	c.logger.Warn("ARCHIVE IS INCOMPLETE: messages remain after RetrivalLimit pages. Increase the limit to archive all messages.",
+		"method", method,
+		"channel", channelID,
+		"thread_ts", threadTs,
+		"fetched", fetched,
+		"retrival_limit", c.config.RetrivalLimit)
 	return nil
 }
 
-func (c *SlackCollector) getHistoryMessagesInThread(ctx context.Context) error {
-	client := c.slackClient
-	config := c.config
+// rescanTruncated reports that the thread lookback rescan stopped at RetrivalLimit.
+// Unlike truncated, it never fails: the new messages are complete, only replies to older threads may be missed.
+func (c *SlackCollector) rescanTruncated(method, channelID, threadTs string, fetched int) {
+	c.threadsIncomplete.Store(true)
+	c.logger.Warn("thread lookback is incomplete: older threads may miss new replies. Increase the limit or shorten the lookback.",
+		"method", method,
+		"channel", channelID,
+		"thread_ts", threadTs,
+		"fetched", fetched,
+		"retrival_limit", c.config.RetrivalLimit)
+}
 
+func (c *SlackCollector) getHistoryMessagesInThread(ctx context.Context, target *Target) error {
 	var threadBaseMessages = []slack.Message{}
 	for _, msg := range c.messages {
 		if msg.ReplyCount != 0 {
@@ -180,101 +486,234 @@ func (c *SlackCollector) getHistoryMessagesInThread(ctx context.Context) error {
 	}
 
 	// conversations.replies
+	// NOTE: 結果の順番を保つため、スレッドごとの結果はindexで持ってから詰める
+	replies := make([][]slack.Message, len(threadBaseMessages))
+	err := parallel(ctx, c.config.Concurrency, len(threadBaseMessages), func(ctx context.Context, i int) error {
+		msgs, err := c.getReplies(ctx, target, threadBaseMessages[i].Timestamp)
+		if err != nil {
+			return err
+		}
+		replies[i] = msgs
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
 	var msgCount = 0
-	for _, baseMsg := range threadBaseMessages {
-		if _, ok := c.replyMessages[baseMsg.Timestamp]; !ok {
-			c.replyMessages[baseMsg.Timestamp] = []slack.Message{}
-		}
-
-		var cur string = ""
-		var count = 0
-		for count < config.RetrivalLimit {
-			count++
-			params := &slack.GetConversationRepliesParameters{
-				ChannelID:          config.Channel,
-				Timestamp:          baseMsg.Timestamp,
-				Cursor:             cur,
-				Limit:              config.HistoryLimit,
-				IncludeAllMetadata: false,
-			}
-			if config.HistryLatest != "" {
-				params.Latest = config.HistryLatest
-			}
-			if config.HistryOldest != "" {
-				params.Oldest = config.HistryOldest
-			}
+	for i, baseMsg := range threadBaseMessages {
+		c.replyMessages[baseMsg.Timestamp] = replies[i]
+		msgCount += len(replies[i])
+	}
 
-			msgs, hasMore, nextCursor, err := client.GetConversationRepliesContext(ctx, params)
-			if err != nil {
-				return err
-			}
+	c.logger.Info(fmt.Sprintf("SlackCollector: getHistoryMessagesInThread success. channel: %s, reply_count: %d", target.ChannelID, msgCount))
+	return nil
+}
 
-			c.replyMessages[baseMsg.Timestamp] = append(c.replyMessages[baseMsg.Timestamp], msgs...)
+func (c *SlackCollector) getReplies(ctx context.Context, target *Target, threadTs string) ([]slack.Message, error) {
+	client := c.slackClient
+	config := c.config
 
-			if !hasMore {
-				break
-			}
-			cur = nextCursor
+	var messages = []slack.Message{}
+
+	var cur string = ""
+	var count = 0
+	var hasMore = true
+	for hasMore && (config.RetrivalLimit < 0 || count < config.RetrivalLimit) {
+		count++
+		params := &slack.GetConversationRepliesParameters{
+			ChannelID:          target.ChannelID,
+			Timestamp:          threadTs,
+			Cursor:             cur,
+			Limit:              config.HistoryLimit,
+			IncludeAllMetadata: false,
+		}
+		if !target.Until.IsZero() {
+			params.Latest = slackTimestamp(target.Until)
+		}
+		if oldest, ok := c.threadOldest[threadTs]; ok {
+			params.Oldest = oldest
+		} else if !target.Since.IsZero() {
+			params.Oldest = slackTimestamp(target.Since)
 		}
-		msgCount += len(c.replyMessages[baseMsg.Timestamp])
-	}
 
-	c.logger.Info(fmt.Sprintf("SlackCollector: getHistoryMessagesInThread success. reply_count: %d", msgCount))
-	return nil
+		var msgs []slack.Message
+		var nextCursor string
+		err := c.withRetry(ctx, "conversations.replies", func() (err error) {
+			msgs, hasMore, nextCursor, err = client.GetConversationRepliesContext(ctx, params)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, msgs...)
+
+		cur = nextCursor
+	}
+	if hasMore {
+		if _, rescanned := c.threadOldest[threadTs]; rescanned {
+			c.rescanTruncated("conversations.replies", target.ChannelID, threadTs, len(messages))
+			return messages, nil
+		}
+		if err := c.truncated("conversations.replies", target.ChannelID, threadTs, len(messages)); err != nil {
+			return nil, err
+		}
+	}
+	return messages, nil
 }
 
 func (c *SlackCollector) getUserdata(ctx context.Context) error {
-	for _, msg := range c.messages {
-		c.userCache.putIfNotExist(msg.User, "")
-		// NOTE: リアクションのアーカイブ非対応なのでユーザーID検索もスキップ
-		// for _, r := range msg.Reactions {
-		// }
-	}
+	messages := append([]slack.Message{}, c.messages...)
 	for _, msgs := range c.replyMessages {
-		for _, msg := range msgs {
-			c.userCache.putIfNotExist(msg.User, "")
-			// NOTE: リアクションのアーカイブ非対応なのでユーザーID検索もスキップ
-			// for _, r := range msg.Reactions {
-			// }
+		messages = append(messages, msgs...)
+	}
+
+	var hasUsergroupMention bool
+	for _, msg := range messages {
+		msg = unwrapMessage(msg)
+		for _, uid := range referencedUsers(msg) {
+			c.userCache.putIfNotExist(uid, "")
+		}
+		for _, cid := range mrkdwnMentions(msg.Text, mrkdwnChannel) {
+			c.channelCache.putIfNotExist(cid, "")
+		}
+		if len(mrkdwnMentions(msg.Text, mrkdwnUsergroup)) != 0 {
+			hasUsergroupMention = true
+		}
+		_, channels, usergroups := blockMentions(msg)
+		for _, cid := range channels {
+			c.channelCache.putIfNotExist(cid, "")
+		}
+		if len(usergroups) != 0 {
+			hasUsergroupMention = true
 		}
 	}
 
 	if err := c.userdataFetchAll(ctx); err != nil {
 		return err
 	}
+	if err := c.channelsFetchAll(ctx); err != nil {
+		return err
+	}
+	if hasUsergroupMention && !c.usergroupsFetched {
+		c.usergroupsFetchAll(ctx)
+	}
 
 	c.logger.Info(fmt.Sprintf("SlackCollector: getUserdata success. users_num: %d", len(c.userCache.cache)))
 	return nil
 }
 
-func (c *SlackCollector) getUsername(ctx context.Context, uid string) (string, error) {
-	uprof, err := c.slackClient.GetUserProfileContext(ctx, &slack.GetUserProfileParameters{
-		UserID:        uid,
-		IncludeLabels: false,
+// referencedUsers returns the IDs of the author and the users mentioned, reacted or edited in the message.
+func referencedUsers(msg slack.Message) []string {
+	uids := []string{}
+	add := func(uid string) {
+		if uid != "" && !slices.Contains(uids, uid) {
+			uids = append(uids, uid)
+		}
+	}
+	add(msg.User)
+	if msg.Edited != nil {
+		add(msg.Edited.User)
+	}
+	for _, uid := range mrkdwnMentions(msg.Text, mrkdwnUser) {
+		add(uid)
+	}
+	users, _, _ := blockMentions(msg)
+	for _, uid := range users {
+		add(uid)
+	}
+	for _, r := range msg.Reactions {
+		for _, uid := range r.Users {
+			add(uid)
+		}
+	}
+	return uids
+}
+
+func (c *SlackCollector) getUser(ctx context.Context, uid string) (*slack.User, error) {
+	var user *slack.User
+	err := c.withRetry(ctx, "users.info", func() (err error) {
+		user, err = c.slackClient.GetUserInfoContext(ctx, uid)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to GetUserInfo(%s): %w", uid, err)
+	}
+	return user, nil
+}
+
+func (c *SlackCollector) channelsFetchAll(ctx context.Context) error {
+	cids := []string{}
+	for cid, name := range c.channelCache.cache {
+		if name == "" {
+			cids = append(cids, cid)
+		}
+	}
+
+	names := make([]string, len(cids))
+	err := parallel(ctx, c.config.Concurrency, len(cids), func(ctx context.Context, i int) error {
+		names[i] = c.getChannel(ctx, cids[i]).Name
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, cid := range cids {
+		c.channelCache.cache[cid] = names[i]
+	}
+	return nil
+}
+
+func (c *SlackCollector) usergroupsFetchAll(ctx context.Context) {
+	c.usergroupsFetched = true
+
+	var groups []slack.UserGroup
+	err := c.withRetry(ctx, "usergroups.list", func() (err error) {
+		groups, err = c.slackClient.GetUserGroupsContext(ctx)
+		return err
 	})
 	if err != nil {
-		return "", fmt.Errorf("failed to GetUserProfile(%s): %w", uid, err)
+		// NOTE: usergroups:read スコープが無い場合もあるので、取れなければIDのまま出す
+		c.logger.Warn("failed to get usergroups", "error", err.Error())
+		return
+	}
+	for _, g := range groups {
+		c.usergroupCache.cache[g.ID] = firstString([]string{g.Handle, g.Name})
+	}
+}
+
+func (c *SlackCollector) mrkdwn() *mrkdwnRenderer {
+	return &mrkdwnRenderer{
+		users:      c.userCache,
+		channels:   c.channelCache,
+		usergroups: c.usergroupCache,
+		timeFormat: c.config.TimeFormat,
 	}
-	return uprof.DisplayName, nil
 }
 
-type userCacheClient struct {
+type nameCacheClient struct {
 	cache map[string]string
 }
 
-func (c *SlackCollector) outputs() (Outputs, error) {
+func (c *SlackCollector) outputs(target *Target) (Outputs, error) {
 	var outputs Outputs
 
 	for _, msg := range c.messages {
 		if msg.SubType == "thread_broadcast" {
 			continue
 		}
+		if c.config.ExcludeSystemMessages && isSystemMessage(msg) {
+			continue
+		}
 
 		output, err := c.slackMessageToOutput(msg)
 		if err != nil {
 			c.logger.Error("failed to convert slackMessage to archive.Output", "error", err)
 			continue
 		}
+		output.Permalink = c.permalink(target.ChannelID, output.ID, "")
 
 		// リプライがある場合は後ろにくっつける
 		if replies, ok := c.replyMessages[msg.Timestamp]; ok {
@@ -289,6 +728,10 @@ func (c *SlackCollector) outputs() (Outputs, error) {
 				if msg.Timestamp == reply.Timestamp {
 					continue
 				}
+				if c.config.ExcludeSystemMessages && isSystemMessage(reply) {
+					continue
+				}
+				outputReply.Permalink = c.permalink(target.ChannelID, outputReply.ID, output.ID)
 				outputReplies = append(outputReplies, outputReply)
 			}
 			output.Replies = outputReplies
@@ -301,9 +744,21 @@ func (c *SlackCollector) outputs() (Outputs, error) {
 }
 
 func (c *SlackCollector) slackMessageToOutput(msg slack.Message) (*Output, error) {
-	var displayName string
+	msg = unwrapMessage(msg)
+
+	var displayName, avatar string
+	switch {
+	case msg.User != "":
+		avatar = c.avatarCache.cache[msg.User]
+	case msg.BotProfile != nil && msg.BotProfile.Icons != nil:
+		avatar = msg.BotProfile.Icons.Image48
+	case msg.Icons != nil:
+		avatar = msg.Icons.IconURL
+	}
 	if msg.Username != "" {
 		displayName = msg.Username
+	} else if msg.User == "" && msg.BotProfile != nil {
+		displayName = msg.BotProfile.Name
 	} else {
 		var ok bool
 		displayName, ok = c.userCache.cache[msg.User]
@@ -312,40 +767,151 @@ func (c *SlackCollector) slackMessageToOutput(msg slack.Message) (*Output, error
 		}
 	}
 
-	tsMicro, err := strconv.ParseInt(strings.ReplaceAll(msg.Timestamp, ".", ""), 10, 64)
+	timestamp, err := parseSlackTimestamp(msg.Timestamp)
 	if err != nil {
-		return nil, fmt.Errorf("failed to ParseInt: %s", msg.Timestamp)
+		return nil, err
 	}
-	timestamp := time.UnixMicro(tsMicro)
-	text := c.userCache.replaceAll(msg.Text)
+	text := c.mrkdwn().render(msg.Text)
+
+	// NOTE: ユーザーの投稿にはTextと同じ内容のrich_textブロックが付くので、bot以外はrich_textを省く
+	slackBlocks := []slack.Block{}
+	for _, block := range msg.Blocks.BlockSet {
+		if msg.BotID == "" && block.BlockType() == slack.MBTRichText {
+			continue
+		}
+		slackBlocks = append(slackBlocks, block)
+	}
+	blocks := c.mrkdwn().renderBlocks(slackBlocks)
+	attachments := c.mrkdwn().renderAttachments(msg.Attachments)
 
 	// Attachment Files
 	files := []*LocalFile{}
 	for _, slackFile := range msg.Files {
 		tempPath, ok := c.tempFilePaths[slackFile.ID]
-		if !ok {
+		exists := c.existingFiles[slackFile.ID]
+		if !ok && !exists {
 			continue
 		}
-		f := &LocalFile{
-			id:        slackFile.ID,
-			timestamp: slackFile.Timestamp.Time(),
-			name:      slackFile.Name,
-			path:      tempPath,
-		}
+		f := newLocalFileFromSlack(slackFile, tempPath)
+		f.exists = exists
 		files = append(files, f)
 	}
 
+	reactions := []*Reaction{}
+	for _, r := range msg.Reactions {
+		users := []string{}
+		for _, uid := range r.Users {
+			users = append(users, c.userCache.get(uid))
+		}
+		reactions = append(reactions, &Reaction{
+			Name:  r.Name,
+			Count: r.Count,
+			Users: users,
+		})
+	}
+
+	var edited *Edit
+	if msg.Edited != nil {
+		editedAt, err := parseSlackTimestamp(msg.Edited.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		edited = &Edit{
+			UserID:    msg.Edited.User,
+			Username:  c.userCache.get(msg.Edited.User),
+			Timestamp: editedAt,
+		}
+	}
+
 	return &Output{
-		Timestamp:  timestamp,
-		Username:   displayName,
-		Text:       text,
-		LocalFiles: files,
+		ID:              msg.Timestamp,
+		ThreadTimestamp: msg.ThreadTimestamp,
+		Timestamp:       timestamp,
+		UserID:          msg.User,
+		Username:        displayName,
+		UserAvatar:      avatar,
+		Text:            text,
+		Blocks:          blocks,
+		Attachments:     attachments,
+		SubType:         msg.SubType,
+		Edited:          edited,
+		Deleted:         msg.SubType == "message_deleted" || msg.SubType == "tombstone",
+		LocalFiles:      files,
+		Reactions:       reactions,
+
+		raw:   &msg,
+		users: c.referencedSlackUsers(msg),
 	}, nil
 }
 
-func (c *SlackCollector) getAllFiles(ctx context.Context) error {
+func (c *SlackCollector) referencedSlackUsers(msg slack.Message) []*slack.User {
+	users := []*slack.User{}
+	for _, uid := range referencedUsers(msg) {
+		if u, ok := c.users[uid]; ok {
+			users = append(users, u)
+		}
+	}
+	return users
+}
+
+// unwrapMessage returns the message content of message_changed and message_deleted events.
+func unwrapMessage(msg slack.Message) slack.Message {
+	switch {
+	case msg.SubType == "message_changed" && msg.SubMessage != nil:
+		inner := slack.Message{Msg: *msg.SubMessage}
+		if inner.SubType == "" {
+			inner.SubType = msg.SubType
+		}
+		return inner
+	case msg.SubType == "message_deleted" && msg.PreviousMessage != nil:
+		inner := slack.Message{Msg: *msg.PreviousMessage}
+		inner.SubType = msg.SubType
+		return inner
+	}
+	return msg
+}
+
+// systemSubTypes are messages posted by Slack on channel events, not by users.
+// ref: https://api.slack.com/events/message#subtypes
+var systemSubTypes = map[string]bool{
+	"channel_join":      true,
+	"channel_leave":     true,
+	"channel_topic":     true,
+	"channel_purpose":   true,
+	"channel_name":      true,
+	"channel_archive":   true,
+	"channel_unarchive": true,
+	"group_join":        true,
+	"group_leave":       true,
+	"group_topic":       true,
+	"group_purpose":     true,
+	"group_name":        true,
+	"group_archive":     true,
+	"group_unarchive":   true,
+	"pinned_item":       true,
+	"unpinned_item":     true,
+}
+
+func isSystemMessage(msg slack.Message) bool {
+	return systemSubTypes[msg.SubType]
+}
+
+func newLocalFileFromSlack(slackFile slack.File, path string) *LocalFile {
+	return &LocalFile{
+		id:        slackFile.ID,
+		timestamp: slackFile.Timestamp.Time(),
+		name:      slackFile.Name,
+		path:      path,
+		user:      slackFile.User,
+		size:      int64(slackFile.Size),
+		mimetype:  slackFile.Mimetype,
+	}
+}
+
+func (c *SlackCollector) getAllFiles(ctx context.Context, target *Target) error {
 	files := []slack.File{}
 	for _, msg := range c.messages {
+		msg = unwrapMessage(msg)
 		for _, f := range msg.Files {
 			if f.Size == 0 {
 				continue
@@ -355,6 +921,7 @@ func (c *SlackCollector) getAllFiles(ctx context.Context) error {
 	}
 	for _, msgs := range c.replyMessages {
 		for _, msg := range msgs {
+			msg = unwrapMessage(msg)
 			for _, f := range msg.Files {
 				if f.Size == 0 {
 					continue
@@ -364,67 +931,188 @@ func (c *SlackCollector) getAllFiles(ctx context.Context) error {
 		}
 	}
 
+	// NOTE: 同じファイルが複数のメッセージに付くことがあるので、ダウンロード前にIDで重複を除く
+	uniqueFiles := []slack.File{}
+	seen := map[string]bool{}
 	for _, f := range files {
-		p, err := c.getFileAndPutTemporaryPath(ctx, f)
+		if _, ok := c.tempFilePaths[f.ID]; ok || c.existingFiles[f.ID] || seen[f.ID] {
+			continue
+		}
+		seen[f.ID] = true
+		uniqueFiles = append(uniqueFiles, f)
+	}
+
+	uniqueFiles, err := c.skipExistingFiles(ctx, target, uniqueFiles)
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, len(uniqueFiles))
+	err = parallel(ctx, c.config.Concurrency, len(uniqueFiles), func(ctx context.Context, i int) error {
+		p, err := c.getFileAndPutTemporaryPath(ctx, uniqueFiles[i])
 		if err != nil {
 			return err
 		}
-		c.tempFilePaths[f.ID] = p
+		paths[i] = p
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i, f := range uniqueFiles {
+		c.tempFilePaths[f.ID] = paths[i]
 	}
 
-	c.logger.Info(fmt.Sprintf("SlackCollector: getAllFiles success. files_num: %d", len(c.tempFilePaths)))
+	c.logger.Info(fmt.Sprintf("SlackCollector: getAllFiles success. files_num: %d, existing_files_num: %d", len(c.tempFilePaths), len(c.existingFiles)))
 	return nil
 }
 
+// fileContentFormatter is implemented by formatters which read the downloaded files, such as HTMLFormatter.
+type fileContentFormatter interface {
+	needsFileContent() bool
+}
+
+// skipExistingFiles returns files which the file exporter does not have yet.
+// If the exporter cannot check, the file is downloaded as usual.
+func (c *SlackCollector) skipExistingFiles(ctx context.Context, target *Target, files []slack.File) ([]slack.File, error) {
+	checker, ok := c.archiveConfig.FileExporter.(FileExistsInterface)
+	if !ok {
+		return files, nil
+	}
+	// NOTE: 画像を埋め込むFormatterにはファイルの中身が要るので、出力先にあってもダウンロードする
+	if f, ok := c.archiveConfig.Formatter.(fileContentFormatter); ok && f.needsFileContent() {
+		return files, nil
+	}
+
+	exists := make([]bool, len(files))
+	err := parallel(ctx, c.config.Concurrency, len(files), func(ctx context.Context, i int) error {
+		ok, err := checker.Exists(ctx, target, newLocalFileFromSlack(files[i], ""))
+		if err != nil {
+			c.logger.Warn("failed to check the file in the file exporter", "file", files[i].ID, "error", err.Error())
+			return nil
+		}
+		exists[i] = ok
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := []slack.File{}
+	for i, f := range files {
+		if exists[i] {
+			c.existingFiles[f.ID] = true
+			continue
+		}
+		res = append(res, f)
+	}
+	return res, nil
+}
+
 func (c *SlackCollector) getFileAndPutTemporaryPath(ctx context.Context, slackFile slack.File) (string, error) {
 	path := path.Join(c.tempFileDir, slackFile.ID)
-	if _, ok := c.tempFilePaths[slackFile.ID]; ok {
-		// Already downloaded
-		return path, nil
-	}
 
 	f, err := os.Create(path)
 	if err != nil {
 		return "", err
 	}
 	defer f.Close()
-	if err := c.slackClient.GetFileContext(ctx, slackFile.URLPrivate, f); err != nil {
+	err = c.withRetry(ctx, "GetFile", func() error {
+		// NOTE: リトライ時は途中まで書いた内容を捨てる
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		return c.slackClient.GetFileContext(ctx, slackFile.URLPrivate, f)
+	})
+	if err != nil {
 		return "", err
 	}
 	return path, nil
 }
 
-func newUserCacheClient() *userCacheClient {
-	return &userCacheClient{
+func newNameCacheClient() *nameCacheClient {
+	return &nameCacheClient{
 		cache: map[string]string{},
 	}
 }
 
-func (ucc *userCacheClient) putIfNotExist(key, value string) {
-	if _, ok := ucc.cache[key]; !ok {
-		ucc.cache[key] = value
+func (ncc *nameCacheClient) putIfNotExist(key, value string) {
+	if key == "" {
+		return
+	}
+	if _, ok := ncc.cache[key]; !ok {
+		ncc.cache[key] = value
 	}
 }
 
-func (ucc *userCacheClient) replaceAll(str string) string {
-	result := str
-	for uid, name := range ucc.cache {
-		result = strings.ReplaceAll(result, uid, name)
+// get returns the cached name, or key itself if the name is unknown.
+func (ncc *nameCacheClient) get(key string) string {
+	if name := ncc.cache[key]; name != "" {
+		return name
 	}
-	return result
+	return key
 }
 
 func (c *SlackCollector) userdataFetchAll(ctx context.Context) error {
+	uids := []string{}
 	for uid, name := range c.userCache.cache {
 		if name == "" {
-			displayName, err := c.getUsername(ctx, uid)
-			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				c.userCache.cache[uid] = uid
-				continue
-			}
-			c.userCache.cache[uid] = displayName
+			uids = append(uids, uid)
 		}
 	}
+
+	users := make([]*slack.User, len(uids))
+	err := parallel(ctx, c.config.Concurrency, len(uids), func(ctx context.Context, i int) error {
+		user, err := c.getUser(ctx, uids[i])
+		if err != nil {
+			c.logger.Warn("failed to get username", "user", uids[i], "error", err.Error())
+			return nil
+		}
+		users[i] = user
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, uid := range uids {
+		user := users[i]
+		if user == nil {
+			c.userCache.cache[uid] = uid
+			continue
+		}
+		c.userCache.cache[uid] = firstString([]string{
+			user.Profile.DisplayName,
+			user.Profile.RealName,
+			user.Name,
+		})
+		c.avatarCache.putIfNotExist(uid, user.Profile.Image48)
+		c.users[uid] = user
+	}
 	return nil
 }
+
+// slackTimestamp formats t as Slack ts such as "1719800000.123456".
+func slackTimestamp(t time.Time) string {
+	return fmt.Sprintf("%d.%06d", t.Unix(), t.Nanosecond()/1000)
+}
+
+func parseSlackTimestamp(ts string) (time.Time, error) {
+	sec, micro, _ := strings.Cut(ts, ".")
+	s, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to ParseInt: %s", ts)
+	}
+	var us int64
+	if micro != "" {
+		micro = (micro + "000000")[:6]
+		us, err = strconv.ParseInt(micro, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to ParseInt: %s", ts)
+		}
+	}
+	return time.Unix(s, us*1000), nil
+}
@@ -0,0 +1,286 @@
+package archive
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+type SlackExportCollectorConfig struct {
+	// Path is the directory or ZIP file of a Slack workspace export.
+	Path string
+	// Channels are channel IDs or names to archive. Empty means all channels in the export.
+	Channels []string
+
+	// DownloadFiles downloads attached files from url_private.
+	// Token is sent if set; files of exports usually need no token.
+	DownloadFiles bool
+	Token         string
+
+	ExcludeSystemMessages bool
+	Concurrency           int
+}
+
+// SlackExportCollector reads messages from a Slack workspace export instead of the Slack API.
+// Only the time window of the target is used in incremental mode; thread checkpoints are ignored.
+type SlackExportCollector struct {
+	config *SlackExportCollectorConfig
+
+	fsys   fs.FS
+	closer func() error
+
+	// NOTE: Outputsへの変換はSlackCollectorと同じものを使う
+	converter *SlackCollector
+	channels  map[string]*slack.Channel
+
+	logger *slog.Logger
+}
+
+// Interface implementation check
+var _ CollectorInterface = (*SlackExportCollector)(nil)
+
+func NewSlackExportCollector(conf *Config, exportConf *SlackExportCollectorConfig) (*SlackExportCollector, error) {
+	if exportConf.Path == "" {
+		return nil, fmt.Errorf("Path is required.")
+	}
+	stat, err := os.Stat(exportConf.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &SlackExportCollector{
+		config:   exportConf,
+		channels: map[string]*slack.Channel{},
+		logger:   conf.Logger,
+	}
+	if stat.IsDir() {
+		c.fsys = os.DirFS(exportConf.Path)
+		c.closer = func() error { return nil }
+	} else {
+		zr, err := zip.OpenReader(exportConf.Path)
+		if err != nil {
+			return nil, err
+		}
+		c.fsys = zr
+		c.closer = zr.Close
+	}
+
+	concurrency := 4
+	if exportConf.Concurrency > 0 {
+		concurrency = exportConf.Concurrency
+	}
+	c.converter = NewSlackCollector(conf, &SlackCollectorConfig{
+		Token:                 exportConf.Token,
+		ExcludeSystemMessages: exportConf.ExcludeSystemMessages,
+		Retry:                 DefaultRetryPolicy(),
+		Concurrency:           concurrency,
+		TimeFormat:            formatterTimeFormat(conf.Formatter),
+	})
+
+	if err := c.loadUsers(); err != nil {
+		c.Clean()
+		return nil, err
+	}
+	if err := c.loadChannels(); err != nil {
+		c.Clean()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *SlackExportCollector) Clean() {
+	c.converter.Clean()
+	if err := c.closer(); err != nil {
+		c.logger.Error("an error occurred", "function", "zip.ReadCloser.Close", "error", err.Error())
+	}
+}
+
+func (c *SlackExportCollector) loadUsers() error {
+	users := []*slack.User{}
+	if err := c.readJSON("users.json", &users); err != nil {
+		return err
+	}
+	for _, user := range users {
+		c.converter.userCache.putIfNotExist(user.ID, firstString([]string{
+			user.Profile.DisplayName,
+			user.Profile.RealName,
+			user.Name,
+		}))
+		c.converter.avatarCache.putIfNotExist(user.ID, user.Profile.Image48)
+		c.converter.users[user.ID] = user
+	}
+	return nil
+}
+
+// loadChannels reads public channels, and private channels if the export has them.
+func (c *SlackExportCollector) loadChannels() error {
+	for _, name := range []string{"channels.json", "groups.json"} {
+		channels := []*slack.Channel{}
+		err := c.readJSON(name, &channels)
+		if errors.Is(err, fs.ErrNotExist) && name != "channels.json" {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, ch := range channels {
+			c.channels[ch.ID] = ch
+			c.converter.channelCache.putIfNotExist(ch.ID, ch.Name)
+		}
+	}
+	return nil
+}
+
+func (c *SlackExportCollector) readJSON(name string, v any) error {
+	b, err := fs.ReadFile(c.fsys, name)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("failed to unmarshal %s: %w", name, err)
+	}
+	return nil
+}
+
+func (c *SlackExportCollector) Targets(ctx context.Context) ([]*Target, error) {
+	channels := []*slack.Channel{}
+	if len(c.config.Channels) == 0 {
+		for _, ch := range c.channels {
+			channels = append(channels, ch)
+		}
+		sort.Slice(channels, func(i, j int) bool { return channels[i].Name < channels[j].Name })
+	} else {
+		seen := map[string]bool{}
+		for _, idOrName := range c.config.Channels {
+			ch := c.findChannel(idOrName)
+			if ch == nil {
+				return nil, fmt.Errorf("channel %s is not in the export", idOrName)
+			}
+			if !seen[ch.ID] {
+				seen[ch.ID] = true
+				channels = append(channels, ch)
+			}
+		}
+	}
+	if len(channels) == 0 {
+		return nil, fmt.Errorf("no channel in the export")
+	}
+
+	targets := []*Target{}
+	for _, ch := range channels {
+		targets = append(targets, &Target{
+			ChannelID:   ch.ID,
+			ChannelName: ch.Name,
+			Since:       c.converter.archiveConfig.Since,
+			Until:       c.converter.archiveConfig.Until,
+			channel:     ch,
+		})
+	}
+
+	c.logger.Info(fmt.Sprintf("SlackExportCollector: Targets success. channel_count: %d", len(targets)))
+	return targets, nil
+}
+
+func (c *SlackExportCollector) findChannel(idOrName string) *slack.Channel {
+	if ch, ok := c.channels[idOrName]; ok {
+		return ch
+	}
+	for _, ch := range c.channels {
+		if ch.Name == strings.TrimPrefix(idOrName, "#") {
+			return ch
+		}
+	}
+	return nil
+}
+
+func (c *SlackExportCollector) Execute(ctx context.Context, target *Target) (Outputs, error) {
+	c.converter.reset()
+
+	messages, err := c.readMessages(target)
+	if err != nil {
+		return nil, err
+	}
+
+	// NOTE: エクスポートではリプライもチャンネルのメッセージと同じ日付ファイルに入っている
+	replies := map[string][]slack.Message{}
+	for _, msg := range messages {
+		ts, err := parseSlackTimestamp(msg.Timestamp)
+		if err != nil {
+			c.logger.Warn("invalid message ts", "channel", target.ChannelID, "ts", msg.Timestamp)
+			continue
+		}
+		if !target.Until.IsZero() && !ts.Before(target.Until) {
+			continue
+		}
+		if msg.ThreadTimestamp != "" && msg.ThreadTimestamp != msg.Timestamp {
+			replies[msg.ThreadTimestamp] = append(replies[msg.ThreadTimestamp], msg)
+			continue
+		}
+		if ts.Before(target.Since) {
+			continue
+		}
+		c.converter.messages = append(c.converter.messages, msg)
+	}
+	for _, msg := range c.converter.messages {
+		if r, ok := replies[msg.Timestamp]; ok {
+			c.converter.replyMessages[msg.Timestamp] = r
+		}
+	}
+
+	if c.config.DownloadFiles {
+		if err := c.converter.getAllFiles(ctx, target); err != nil {
+			return nil, err
+		}
+	}
+
+	outputs, err := c.converter.outputs(target)
+	if err != nil {
+		return nil, err
+	}
+	c.logger.Info(fmt.Sprintf("SlackExportCollector: Execute success. channel: %s, messages_num: %d", target.ChannelID, len(outputs)))
+	return outputs, nil
+}
+
+// readMessages reads the day files which may contain messages of the target window.
+func (c *SlackExportCollector) readMessages(target *Target) ([]slack.Message, error) {
+	dir := firstString([]string{target.ChannelName, target.ChannelID})
+	names, err := fs.Glob(c.fsys, path.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	messages := []slack.Message{}
+	for _, name := range names {
+		// NOTE: 日付ファイルはワークスペースのタイムゾーンなので、前後1日の余裕を持って読む
+		day, err := time.Parse("2006-01-02", strings.TrimSuffix(path.Base(name), ".json"))
+		if err != nil {
+			continue
+		}
+		if day.Before(target.Since.AddDate(0, 0, -1)) {
+			continue
+		}
+		if !target.Until.IsZero() && day.After(target.Until.AddDate(0, 0, 1)) {
+			continue
+		}
+
+		msgs := []slack.Message{}
+		if err := c.readJSON(name, &msgs); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msgs...)
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].Timestamp < messages[j].Timestamp })
+	return messages, nil
+}
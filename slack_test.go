@@ -0,0 +1,244 @@
+package archive
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// fakeSlack is a local Slack API server. Handlers are keyed by method such as "conversations.info".
+type fakeSlack struct {
+	t *testing.T
+
+	mu       sync.Mutex
+	handlers map[string]http.HandlerFunc
+	calls    map[string][]time.Time
+}
+
+func newFakeSlack(t *testing.T) *fakeSlack {
+	return &fakeSlack{
+		t:        t,
+		handlers: map[string]http.HandlerFunc{},
+		calls:    map[string][]time.Time{},
+	}
+}
+
+func (f *fakeSlack) handle(method string, h http.HandlerFunc) {
+	f.handlers[method] = h
+}
+
+func (f *fakeSlack) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method := r.URL.Path[1:]
+	f.mu.Lock()
+	f.calls[method] = append(f.calls[method], time.Now())
+	h, ok := f.handlers[method]
+	f.mu.Unlock()
+	if !ok {
+		f.t.Errorf("unexpected call: %s", method)
+		http.NotFound(w, r)
+		return
+	}
+	h(w, r)
+}
+
+func (f *fakeSlack) callTimes(method string) []time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]time.Time{}, f.calls[method]...)
+}
+
+// respond returns a handler which replies with the responses in order and repeats the last one.
+func respond(responses ...func(w http.ResponseWriter)) http.HandlerFunc {
+	var mu sync.Mutex
+	i := 0
+	return func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		res := responses[i]
+		if i < len(responses)-1 {
+			i++
+		}
+		mu.Unlock()
+		res(w)
+	}
+}
+
+func jsonResponse(body string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	}
+}
+
+func statusResponse(code int, header ...string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		for i := 0; i+1 < len(header); i += 2 {
+			w.Header().Set(header[i], header[i+1])
+		}
+		w.WriteHeader(code)
+	}
+}
+
+func testRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: 4,
+		BaseDelay:   10 * time.Millisecond,
+		MaxDelay:    40 * time.Millisecond,
+	}
+}
+
+func newTestCollector(t *testing.T, fake *fakeSlack, slackConf *SlackCollectorConfig) *SlackCollector {
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	if slackConf.Retry == nil {
+		slackConf.Retry = testRetryPolicy()
+	}
+	if slackConf.Concurrency == 0 {
+		slackConf.Concurrency = 2
+	}
+	if slackConf.HistoryLimit == 0 {
+		slackConf.HistoryLimit = 200
+	}
+	conf := &Config{
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	c := NewSlackCollector(conf, slackConf)
+	c.slackClient = slack.New("xoxb-test", slack.OptionAPIURL(srv.URL+"/"))
+	t.Cleanup(c.Clean)
+	return c
+}
+
+func TestFetchHistoryRetriesEachPage(t *testing.T) {
+	fake := newFakeSlack(t)
+	fake.handle("conversations.history", respond(
+		jsonResponse(`{"ok":true,"messages":[{"type":"message","user":"U1","text":"new","ts":"1700000002.000000"}],"has_more":true,"response_metadata":{"next_cursor":"page2"}}`),
+		statusResponse(http.StatusServiceUnavailable),
+		jsonResponse(`{"ok":true,"messages":[{"type":"message","user":"U1","text":"old","ts":"1700000001.000000"}],"has_more":false}`),
+	))
+	c := newTestCollector(t, fake, &SlackCollectorConfig{RetrivalLimit: UnlimitedRetrival})
+
+	messages, hasMore, err := c.fetchHistory(context.Background(), "C1", "", "", false)
+	if err != nil {
+		t.Fatalf("fetchHistory: %v", err)
+	}
+	if len(messages) != 2 || hasMore {
+		t.Fatalf("messages = %d, hasMore = %v, want 2 and no more", len(messages), hasMore)
+	}
+	if got := len(fake.callTimes("conversations.history")); got != 3 {
+		t.Errorf("conversations.history calls = %d, want 3", got)
+	}
+}
+
+func TestGetChannelFallsBackOnNonRetryableError(t *testing.T) {
+	fake := newFakeSlack(t)
+	fake.handle("conversations.info", respond(
+		jsonResponse(`{"ok":false,"error":"channel_not_found"}`),
+	))
+	c := newTestCollector(t, fake, &SlackCollectorConfig{})
+
+	ch := c.getChannel(context.Background(), "C404")
+	if ch.ID != "C404" || ch.Name != "C404" {
+		t.Errorf("channel = %s/%s, want the ID as the name", ch.ID, ch.Name)
+	}
+	if got := len(fake.callTimes("conversations.info")); got != 1 {
+		t.Errorf("conversations.info calls = %d, want 1", got)
+	}
+}
+
+func TestTruncatedTargetKeepsCheckpoint(t *testing.T) {
+	fake := newFakeSlack(t)
+	fake.handle("conversations.history", respond(
+		jsonResponse(`{"ok":true,"messages":[{"type":"message","user":"U1","text":"new","ts":"1700000002.000000"}],"has_more":true,"response_metadata":{"next_cursor":"page2"}}`),
+	))
+	fake.handle("users.info", respond(
+		jsonResponse(`{"ok":true,"user":{"id":"U1","name":"alice","profile":{"display_name":"alice"}}}`),
+	))
+	c := newTestCollector(t, fake, &SlackCollectorConfig{RetrivalLimit: 1})
+
+	cp := newCheckpoint()
+	cp.Channels["C1"] = &ChannelCheckpoint{LatestTimestamp: "1700000000.000000"}
+	target := &Target{ChannelID: "C1"}
+	if err := cp.apply(target); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+
+	outputs, err := c.Execute(context.Background(), target)
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if len(outputs) != 1 {
+		t.Fatalf("outputs = %d, want 1", len(outputs))
+	}
+	if cp.update(target, outputs, time.Now(), 0) {
+		t.Error("update of a truncated target succeeded")
+	}
+	if got := cp.Channels["C1"].LatestTimestamp; got != "1700000000.000000" {
+		t.Errorf("LatestTimestamp = %s, want the previous checkpoint", got)
+	}
+
+	// NOTE: 次のチャンネルの取得では打ち切りの状態が残らない
+	c.reset()
+	if c.incomplete.Load() {
+		t.Error("reset did not clear truncation")
+	}
+}
+
+func TestTruncatedThreadLookbackMovesCheckpoint(t *testing.T) {
+	fake := newFakeSlack(t)
+	fake.handle("conversations.history", func(w http.ResponseWriter, r *http.Request) {
+		// NOTE: inclusiveはスレッドの再走査。新着は取り切れるが、再走査は打ち切られる
+		if r.FormValue("inclusive") == "1" {
+			jsonResponse(`{"ok":true,"messages":[{"type":"message","user":"U1","text":"thread","ts":"1700000000.000000","reply_count":1,"latest_reply":"1700000003.000000"}],"has_more":true,"response_metadata":{"next_cursor":"page2"}}`)(w)
+			return
+		}
+		jsonResponse(`{"ok":true,"messages":[{"type":"message","user":"U1","text":"new","ts":"1700000002.000000"}],"has_more":false}`)(w)
+	})
+	fake.handle("conversations.replies", respond(
+		jsonResponse(`{"ok":true,"messages":[{"type":"message","user":"U1","text":"thread","ts":"1700000000.000000","thread_ts":"1700000000.000000"},{"type":"message","user":"U1","text":"reply","ts":"1700000003.000000","thread_ts":"1700000000.000000"}],"has_more":false}`),
+	))
+	fake.handle("users.info", respond(
+		jsonResponse(`{"ok":true,"user":{"id":"U1","name":"alice","profile":{"display_name":"alice"}}}`),
+	))
+	c := newTestCollector(t, fake, &SlackCollectorConfig{
+		RetrivalLimit:    1,
+		FailOnTruncation: true,
+		ThreadLookback:   time.Hour,
+	})
+
+	cp := newCheckpoint()
+	cp.Channels["C1"] = &ChannelCheckpoint{
+		LatestTimestamp: "1700000001.000000",
+		Threads:         map[string]string{"1700000000.000000": "1700000001.000000"},
+	}
+	target := &Target{ChannelID: "C1"}
+	if err := cp.apply(target); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+
+	outputs, err := c.Execute(context.Background(), target)
+	if err != nil {
+		t.Fatalf("Execute: %v, want the lookback truncation not to fail", err)
+	}
+	if target.truncated || !target.threadsTruncated {
+		t.Fatalf("truncated = %v, threadsTruncated = %v, want only the lookback truncated", target.truncated, target.threadsTruncated)
+	}
+
+	now, _ := parseSlackTimestamp("1700000004.000000")
+	if !cp.update(target, outputs, now, time.Hour) {
+		t.Fatal("update failed")
+	}
+	ccp := cp.Channels["C1"]
+	if ccp.LatestTimestamp != "1700000002.000000" {
+		t.Errorf("LatestTimestamp = %s, want the newest message", ccp.LatestTimestamp)
+	}
+	if got := ccp.Threads["1700000000.000000"]; got != "1700000001.000000" {
+		t.Errorf("thread checkpoint = %s, want the previous one", got)
+	}
+}
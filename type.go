@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"os"
 	"time"
+
+	"github.com/slack-go/slack"
 )
 
 type Config struct {
@@ -13,22 +15,101 @@ type Config struct {
 	Until  time.Time
 	Logger *slog.Logger
 
-	SlackToken   string
-	SlackChannel string
+	SlackToken    string
+	SlackChannels []string
+	// NOTE: trueの場合はSlackChannelsに加えてbotが参加しているパブリックチャンネルを全てアーカイブする
+	SlackAllJoinedChannels bool
+	// SlackHistoryLimit is the page size of Slack API calls. 0 means the default (200).
+	SlackHistoryLimit int
+	// SlackRetrivalLimit is the max number of pages. 0 means the default (10), UnlimitedRetrival means no limit.
+	SlackRetrivalLimit int
+	// FailOnTruncation makes Run fail a channel instead of warning when messages remain after SlackRetrivalLimit.
+	FailOnTruncation bool
+	// SlackConcurrency is the number of workers fetching from Slack. 0 means the default (4).
+	SlackConcurrency int
+	// ExcludeSystemMessages drops messages such as joins, leaves and topic changes.
+	ExcludeSystemMessages bool
+	// SlackRetry is the retry policy of Slack API calls. nil means DefaultRetryPolicy.
+	SlackRetry *RetryPolicy
+
+	// NOTE: Incrementalの場合はCheckpointStoreに記録されたチャンネルごとの続きからアーカイブする
+	Incremental     bool
+	CheckpointStore CheckpointStoreInterface
+	// ThreadLookback is how far back threads are checked for new replies in incremental mode.
+	// 0 means DefaultThreadLookback, and a negative value disables checking for new replies.
+	ThreadLookback time.Duration
 
+	// Collector is where messages come from. nil means SlackCollector with the Slack* settings above.
+	// Run calls Collector.Clean when it finishes.
+	Collector    CollectorInterface
 	TextExporter TextExporterInterface
 	FileExporter FileExporterInterface
 	Formatter    FormatterInterface
 }
 
+// DefaultThreadLookback is the default of Config.ThreadLookback.
+const DefaultThreadLookback = 7 * 24 * time.Hour
+
+// threadLookback returns Config.ThreadLookback with the default applied. It is 0 if disabled.
+func (c *Config) threadLookback() time.Duration {
+	if c.ThreadLookback == 0 {
+		return DefaultThreadLookback
+	}
+	if c.ThreadLookback < 0 {
+		return 0
+	}
+	return c.ThreadLookback
+}
+
+// Target is a unit of archiving: one channel in the time window.
+type Target struct {
+	ChannelID   string
+	ChannelName string
+	Since       time.Time
+	Until       time.Time
+
+	// Checkpoint is the previous archive state of the channel. nil unless incremental.
+	Checkpoint *ChannelCheckpoint
+
+	// channel is the conversation object from Slack, if the collector has it.
+	channel *slack.Channel
+	// truncated means the collector stopped paginating with messages left, so the checkpoint must not move.
+	truncated bool
+	// threadsTruncated means the thread lookback rescan stopped with threads left.
+	// The checkpoint moves on, but its thread replies are not recorded.
+	threadsTruncated bool
+}
+
 type LocalFile struct {
 	id        string
 	path      string
 	name      string
 	timestamp time.Time
+	// user is the user ID of the uploader, if known.
+	user     string
+	size     int64
+	mimetype string
+	// exists means the file exporter already has the file. path is empty then.
+	exists bool
+}
+
+// NewLocalFile is for custom collectors. path is the downloaded file, which the collector removes in Clean.
+func NewLocalFile(id, name, path string, timestamp time.Time) *LocalFile {
+	return &LocalFile{
+		id:        id,
+		name:      name,
+		path:      path,
+		timestamp: timestamp,
+	}
 }
 
 func (lf *LocalFile) detectContentType() (string, error) {
+	if lf.exists {
+		if lf.mimetype == "" {
+			return "", fmt.Errorf("unknown content type of %s", lf.id)
+		}
+		return lf.mimetype, nil
+	}
 	f, err := os.Open(lf.path)
 	if err != nil {
 		return "", fmt.Errorf("error os.Open %w", err)
@@ -43,17 +124,62 @@ func (lf *LocalFile) detectContentType() (string, error) {
 }
 
 type Output struct {
-	ID        string    `json:"id,omitempty"`
-	Timestamp time.Time `json:"timestamp,omitempty"`
-	Username  string    `json:"username,omitempty"`
-	Text      string    `json:"text,omitempty"`
+	ID              string    `json:"id,omitempty"`
+	Timestamp       time.Time `json:"timestamp,omitempty"`
+	ThreadTimestamp string    `json:"thread_ts,omitempty"`
+	UserID          string    `json:"user_id,omitempty"`
+	Username        string    `json:"username,omitempty"`
+	UserAvatar      string    `json:"user_avatar,omitempty"`
+	Text            string    `json:"text,omitempty"`
+	// Permalink is empty if the workspace URL is unknown.
+	Permalink string `json:"permalink,omitempty"`
+
+	// Blocks and Attachments hold the content of app messages. Text is only a fallback for them.
+	Blocks      []*Block      `json:"blocks,omitempty"`
+	Attachments []*Attachment `json:"attachments,omitempty"`
+
+	// SubType is the Slack message subtype such as "bot_message" or "channel_join".
+	SubType string `json:"subtype,omitempty"`
+	Edited  *Edit  `json:"edited,omitempty"`
+	// Deleted is true for deleted messages, including thread roots left as tombstones.
+	Deleted bool `json:"deleted,omitempty"`
 
 	Replies    Outputs `json:"replies,omitempty"`
 	LocalFiles []*LocalFile
+	Reactions  []*Reaction `json:"reactions,omitempty"`
+
+	// raw is the original Slack message, if the collector has it.
+	raw *slack.Message
+	// users are the author and the users mentioned, reacted or edited in the message.
+	users []*slack.User
+}
+
+type Edit struct {
+	UserID    string    `json:"user_id,omitempty"`
+	Username  string    `json:"username,omitempty"`
+	Timestamp time.Time `json:"timestamp,omitempty"`
+}
+
+type Reaction struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+	// Users are display names. Slack may return fewer users than Count.
+	Users []string `json:"users,omitempty"`
 }
 
 type Outputs []*Output
 
+// newFiles returns files which the file exporter does not have yet.
+func (outputs Outputs) newFiles() []*LocalFile {
+	res := []*LocalFile{}
+	for _, f := range outputs.LocalFiles() {
+		if !f.exists {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
 func (outputs Outputs) LocalFiles() []*LocalFile {
 	res := []*LocalFile{}
 	for _, output := range outputs {
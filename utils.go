@@ -1,9 +1,14 @@
 package archive
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
 )
 
 func firstString(slice []string) string {
@@ -15,6 +20,17 @@ func firstString(slice []string) string {
 	return ""
 }
 
+// SplitList splits comma-separated values, ignoring empty items.
+func SplitList(s string) []string {
+	res := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
 // NOTE: os.Getenv(ENVNAME) or os.Getenv(ENVNAME_BASE64)
 func Getenv(env string) string {
 	envPrefix := "SA_"
@@ -33,3 +49,121 @@ func Getenv(env string) string {
 	}
 	return ""
 }
+
+// templateTimeLayout is the layout of {since} and {until}.
+const templateTimeLayout = "20060102T150405"
+
+// expandTemplate replaces placeholders in s with the values of the target.
+// {channel}: channel ID, {channel_name}: channel name
+// {yyyy}, {mm}, {dd}: date of Since, {since}, {until}: Since and Until such as 20240701T000000
+// NOTE: 日時はプロセスのタイムゾーン(TZ)で展開する
+func expandTemplate(s string, t *Target) string {
+	day := t.Since
+	if day.IsZero() {
+		day = t.Until
+	}
+	if day.IsZero() {
+		day = time.Now()
+	}
+	return expandTemplateAt(s, t, day)
+}
+
+// expandTemplateAt is expandTemplate with {yyyy}, {mm} and {dd} of day.
+func expandTemplateAt(s string, t *Target, day time.Time) string {
+	day = day.Local()
+
+	r := strings.NewReplacer(
+		"{channel}", t.ChannelID,
+		"{channel_name}", t.ChannelName,
+		"{yyyy}", day.Format("2006"),
+		"{mm}", day.Format("01"),
+		"{dd}", day.Format("02"),
+		"{since}", formatTemplateTime(t.Since),
+		"{until}", formatTemplateTime(t.Until),
+	)
+	return r.Replace(s)
+}
+
+func formatTemplateTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format(templateTimeLayout)
+}
+
+// templatePrefix returns the part of s before the first placeholder.
+func templatePrefix(s string) string {
+	if i := strings.Index(s, "{"); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// filePath returns the path of f under the directory template dir.
+// {yyyy}, {mm} and {dd} are the date of the file, so that the file keeps its path when a later run archives it again.
+// The channel ID directory is added unless dir has {channel} or {channel_name}.
+func filePath(dir string, t *Target, f *LocalFile) string {
+	expanded := expandTemplate(dir, t)
+	if !f.timestamp.IsZero() {
+		expanded = expandTemplateAt(dir, t, f.timestamp)
+	}
+	if !hasChannelPlaceholder(dir) {
+		expanded = path.Join(expanded, t.ChannelID)
+	}
+	return path.Join(expanded, fmt.Sprintf("%s_%s", f.id, f.name))
+}
+
+// fileRelPath returns filePath after the last directory of dir without placeholders.
+// For "/tmp/sa/{yyyy}/{mm}" it is such as "2024/07/C0123/F0123_a.png", and for "/tmp/sa" "C0123/F0123_a.png".
+func fileRelPath(dir string, t *Target, f *LocalFile) string {
+	prefix := templatePrefix(dir)
+	if prefix == dir {
+		prefix = strings.TrimSuffix(dir, "/") + "/"
+	}
+	static := prefix[:strings.LastIndex(prefix, "/")+1]
+	return strings.TrimPrefix(filePath(dir, t, f), path.Clean(static)+"/")
+}
+
+// parallel calls fn(ctx, i) for i in [0, n) with at most workers goroutines.
+// After the first error, remaining calls are skipped and ctx passed to running calls is canceled.
+func parallel(ctx context.Context, workers, n int, fn func(ctx context.Context, i int) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+		sem      = make(chan struct{}, workers)
+	)
+	for i := 0; i < n; i++ {
+		select {
+		case <-ctx.Done():
+		case sem <- struct{}{}:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := fn(ctx, i); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
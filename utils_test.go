@@ -0,0 +1,41 @@
+package archive
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFilePath(t *testing.T) {
+	// NOTE: 日付のプレースホルダはプロセスのタイムゾーンで展開する
+	local := time.Local
+	time.Local = time.UTC
+	t.Cleanup(func() { time.Local = local })
+
+	target := &Target{
+		ChannelID:   "C1",
+		ChannelName: "general",
+		Since:       time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC),
+	}
+	file := &LocalFile{id: "F1", name: "a.png", timestamp: time.Date(2024, 7, 15, 12, 0, 0, 0, time.UTC)}
+
+	tests := []struct {
+		dir     string
+		want    string
+		wantRel string
+	}{
+		{dir: "files", want: "files/C1/F1_a.png", wantRel: "C1/F1_a.png"},
+		{dir: "/tmp/sa/", want: "/tmp/sa/C1/F1_a.png", wantRel: "C1/F1_a.png"},
+		{dir: "files/{channel}", want: "files/C1/F1_a.png", wantRel: "C1/F1_a.png"},
+		{dir: "files/{channel_name}/{yyyy}", want: "files/general/2024/F1_a.png", wantRel: "general/2024/F1_a.png"},
+		{dir: "/tmp/sa/{yyyy}/{mm}", want: "/tmp/sa/2024/07/C1/F1_a.png", wantRel: "2024/07/C1/F1_a.png"},
+		{dir: "files/{yyyy}{mm}{dd}", want: "files/20240715/C1/F1_a.png", wantRel: "20240715/C1/F1_a.png"},
+	}
+	for _, tt := range tests {
+		if got := filePath(tt.dir, target, file); got != tt.want {
+			t.Errorf("filePath(%s) = %s, want %s", tt.dir, got, tt.want)
+		}
+		if got := fileRelPath(tt.dir, target, file); got != tt.wantRel {
+			t.Errorf("fileRelPath(%s) = %s, want %s", tt.dir, got, tt.wantRel)
+		}
+	}
+}